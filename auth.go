@@ -0,0 +1,277 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// repoKey is the context key for the repository on whose behalf
+// requests are made.
+type repoKey struct{}
+
+// withRepo returns a context for requests made on behalf of repo.
+func withRepo(ctx context.Context, repo string) context.Context {
+	return context.WithValue(ctx, repoKey{}, repo)
+}
+
+// repoFromContext returns the repository on whose behalf requests are
+// made, or the empty string if unknown.
+func repoFromContext(ctx context.Context) string {
+	repo, _ := ctx.Value(repoKey{}).(string)
+	return repo
+}
+
+// A tokenSource supplies the access token used to authorize requests
+// made on behalf of a repository.
+type tokenSource interface {
+	token(ctx context.Context, repo string) (string, error)
+}
+
+const (
+	// appJWTLifetime is the lifetime of the JSON web tokens used to
+	// authenticate as a GitHub App; GitHub allows at most 10 minutes.
+	appJWTLifetime = 9 * time.Minute
+	// appTokenRefresh is how long before expiry installation tokens are
+	// refreshed, so that none expires during a request.
+	appTokenRefresh = 5 * time.Minute
+)
+
+// installationToken is an access token for a GitHub App installation.
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// appTokenSource supplies installation access tokens for a GitHub App.
+// The installation is either fixed or looked up for each repository.
+// Tokens are cached and refreshed automatically before they expire.
+type appTokenSource struct {
+	c            *Config
+	appID        string
+	key          *rsa.PrivateKey
+	installation int64 // Fixed installation ID; 0 to look up per repository
+
+	mu            sync.Mutex
+	installations map[string]int64             // Repository to installation ID
+	tokens        map[int64]*installationToken // Installation ID to token
+	inflight      map[string]*appCall          // Lookups and refreshes in progress
+}
+
+// An appCall is an installation lookup or token refresh in progress,
+// which concurrent requests for the same installation wait on.
+type appCall struct {
+	done chan struct{} // Closed once the call completes
+	err  error
+}
+
+func newAppTokenSource(c *Config, appID, keyFile string, installation int64) (*appTokenSource, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseRSAPrivateKey(data)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", keyFile, err))
+	}
+	return &appTokenSource{
+		c:             c,
+		appID:         appID,
+		key:           key,
+		installation:  installation,
+		installations: map[string]int64{},
+		tokens:        map[int64]*installationToken{},
+		inflight:      map[string]*appCall{},
+	}, nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded RSA private key, in either
+// the PKCS #1 form issued by GitHub or PKCS #8.
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// jwt returns a JSON web token, signed with the app's private key,
+// which authenticates requests as the app itself.
+func (ats *appTokenSource) jwt() (string, error) {
+	// Backdate the issue time to allow for clock drift.
+	now := time.Now().Add(-time.Minute)
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": ats.appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ats.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// fetchAsApp performs a request authenticated as the app itself.
+func (ats *appTokenSource) fetchAsApp(ctx context.Context, method, url string, value interface{}) error {
+	jwt, err := ats.jwt()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	_, err = fetchRequest(ctx, ats.c, req, value)
+	return err
+}
+
+// flight runs fetch, unless a fetch for the same key is already in
+// flight, in which case it waits for that one to finish and returns its
+// error. Fetches run without ats.mu held and store their results
+// themselves, so that a slow refresh of one token doesn't hold up
+// requests using the others.
+func (ats *appTokenSource) flight(ctx context.Context, key string, fetch func() error) error {
+	ats.mu.Lock()
+	call, ok := ats.inflight[key]
+	if !ok {
+		call = &appCall{done: make(chan struct{})}
+		ats.inflight[key] = call
+	}
+	ats.mu.Unlock()
+	if ok {
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	call.err = fetch()
+	ats.mu.Lock()
+	delete(ats.inflight, key)
+	ats.mu.Unlock()
+	close(call.done)
+	return call.err
+}
+
+// installationFor returns the ID of the app's installation for repo,
+// looking it up unless fixed or previously found.
+func (ats *appTokenSource) installationFor(ctx context.Context, repo string) (int64, error) {
+	if ats.installation != 0 {
+		return ats.installation, nil
+	}
+	if len(repo) == 0 {
+		return 0, errors.New("GitHub App installation unknown for request; specify --app-installation")
+	}
+	ats.mu.Lock()
+	id, ok := ats.installations[repo]
+	ats.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	err := ats.flight(ctx, "installation "+repo, func() error {
+		var inst struct {
+			ID int64 `json:"id"`
+		}
+		url := fmt.Sprintf("%srepos/%s/installation", ats.c.Host, repo)
+		if err := ats.fetchAsApp(ctx, "GET", url, &inst); err != nil {
+			return err
+		}
+		ats.mu.Lock()
+		ats.installations[repo] = inst.ID
+		ats.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("failed to find GitHub App installation for %s: %s", repo, err))
+	}
+	ats.mu.Lock()
+	defer ats.mu.Unlock()
+	return ats.installations[repo], nil
+}
+
+// token implements the tokenSource interface.
+func (ats *appTokenSource) token(ctx context.Context, repo string) (string, error) {
+	id, err := ats.installationFor(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	ats.mu.Lock()
+	t, ok := ats.tokens[id]
+	ats.mu.Unlock()
+	if ok && time.Until(t.ExpiresAt) > appTokenRefresh {
+		return t.Token, nil
+	}
+
+	err = ats.flight(ctx, fmt.Sprintf("token %d", id), func() error {
+		t := &installationToken{}
+		url := fmt.Sprintf("%sapp/installations/%d/access_tokens", ats.c.Host, id)
+		if err := ats.fetchAsApp(ctx, "POST", url, t); err != nil {
+			return err
+		}
+		ats.mu.Lock()
+		ats.tokens[id] = t
+		ats.mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("failed to create token for GitHub App installation %d: %s", id, err))
+	}
+	ats.mu.Lock()
+	defer ats.mu.Unlock()
+	return ats.tokens[id].Token, nil
+}
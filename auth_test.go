@@ -0,0 +1,198 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAppServer serves the GitHub App endpoints used to look up
+// installations and create their access tokens, verifying that each
+// request is authenticated with a JWT signed by the app's key.
+type fakeAppServer struct {
+	t     *testing.T
+	appID string
+	key   *rsa.PublicKey
+
+	mu      sync.Mutex
+	lookups map[string]int // Installation lookups by repository
+	creates map[string]int // Token creations by installation ID
+}
+
+func (s *fakeAppServer) verifyJWT(r *http.Request) error {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return fmt.Errorf("expected a bearer token; got %q", auth)
+	}
+	parts := strings.Split(strings.TrimPrefix(auth, "Bearer "), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("expected a JWT; got %q", auth)
+	}
+	enc := base64.RawURLEncoding
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(s.key, crypto.SHA256, digest[:], sig); err != nil {
+		return err
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	for i, v := range []interface{}{&header, &claims} {
+		data, err := enc.DecodeString(parts[i])
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+	}
+	now := time.Now().Unix()
+	if header.Alg != "RS256" || claims.Iss != s.appID || claims.Iat > now || claims.Exp <= now ||
+		claims.Exp-claims.Iat > int64(10*time.Minute/time.Second) {
+		return fmt.Errorf("unexpected JWT header %+v and claims %+v", header, claims)
+	}
+	return nil
+}
+
+func (s *fakeAppServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verifyJWT(r); err != nil {
+		s.t.Error(err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var repo string
+	var id int64
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/installation"):
+		repo = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/"), "/installation")
+		s.mu.Lock()
+		s.lookups[repo]++
+		s.mu.Unlock()
+		ids := map[string]int64{"o/a": 1, "o/b": 2, "p/c": 1}
+		if id = ids[repo]; id == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"id": %d}`, id)
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/access_tokens"):
+		inst := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/app/installations/"), "/access_tokens")
+		// Give concurrent callers time to pile up behind the refresh.
+		time.Sleep(50 * time.Millisecond)
+		s.mu.Lock()
+		s.creates[inst]++
+		n := s.creates[inst]
+		s.mu.Unlock()
+		expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, `{"token": "token-%s-%d", "expires_at": %q}`, inst, n, expires)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestAppTokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeAppServer{
+		t:       t,
+		appID:   "1234",
+		key:     &key.PublicKey,
+		lookups: map[string]int{},
+		creates: map[string]int{},
+	}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	ats := &appTokenSource{
+		c:             &Config{Host: server.URL + "/"},
+		appID:         s.appID,
+		key:           key,
+		installations: map[string]int64{},
+		tokens:        map[int64]*installationToken{},
+		inflight:      map[string]*appCall{},
+	}
+	ctx := context.Background()
+	expectToken := func(repo, expected string) {
+		token, err := ats.token(ctx, repo)
+		if err != nil {
+			t.Errorf("%s: %s", repo, err)
+		} else if token != expected {
+			t.Errorf("%s: expected token %q; got %q", repo, expected, token)
+		}
+	}
+
+	// Installations are looked up once per repository, and their
+	// tokens are shared by the repositories they cover.
+	expectToken("o/a", "token-1-1")
+	expectToken("o/a", "token-1-1")
+	expectToken("o/b", "token-2-1")
+	expectToken("p/c", "token-1-1")
+	if _, err := ats.token(ctx, "o/missing"); err == nil {
+		t.Error("expected an error for a repository without an installation")
+	}
+	for repo, n := range map[string]int{"o/a": 1, "o/b": 1, "p/c": 1} {
+		if s.lookups[repo] != n {
+			t.Errorf("%s: expected %d installation lookups; got %d", repo, n, s.lookups[repo])
+		}
+	}
+
+	// A token about to expire is refreshed.
+	ats.mu.Lock()
+	ats.tokens[1].ExpiresAt = time.Now().Add(appTokenRefresh - time.Second)
+	ats.mu.Unlock()
+	expectToken("o/a", "token-1-2")
+	expectToken("p/c", "token-1-2")
+
+	// Concurrent callers share a single refresh.
+	ats.mu.Lock()
+	ats.tokens[2].ExpiresAt = time.Now().Add(appTokenRefresh - time.Second)
+	ats.mu.Unlock()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expectToken("o/b", "token-2-2")
+		}()
+	}
+	wg.Wait()
+	if n := s.creates["2"]; n != 2 {
+		t.Errorf("expected 2 tokens created for installation 2; got %d", n)
+	}
+}
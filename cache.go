@@ -0,0 +1,96 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+)
+
+// A responseCache is an on-disk cache of API responses keyed by
+// request. Cached responses are revalidated using conditional requests
+// with the ETag and Last-Modified values of the cached response. A 304
+// (Not Modified) reply is then served from the cache; GitHub does not
+// count these against the rate limit.
+type responseCache struct {
+	dir string
+}
+
+func newResponseCache(dir string) (*responseCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &responseCache{dir: dir}, nil
+}
+
+// get returns the cached response for req, or nil if there is none.
+// Unreadable entries are treated as cache misses.
+func (rc *responseCache) get(req *http.Request) (*recordedResponse, error) {
+	path, err := recordingPath(rc.dir, req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rr recordedResponse
+	if err := json.Unmarshal(data, &rr); err != nil {
+		log.Printf("ignoring corrupt cache entry %q: %s\n", path, err)
+		return nil, nil
+	}
+	return &rr, nil
+}
+
+// put saves the response to req if it carries a validator which can be
+// used to revalidate it later.
+func (rc *responseCache) put(req *http.Request, resp *http.Response, body []byte) error {
+	if len(resp.Header.Get("ETag")) == 0 && len(resp.Header.Get("Last-Modified")) == 0 {
+		return nil
+	}
+	path, err := recordingPath(rc.dir, req)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(recordedResponse{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       string(body),
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// addConditionalHeaders makes req conditional on the cached response
+// having been modified.
+func addConditionalHeaders(req *http.Request, cached *recordedResponse) {
+	if etag := cached.Header.Get("ETag"); len(etag) > 0 {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := cached.Header.Get("Last-Modified"); len(lastModified) > 0 {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
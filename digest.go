@@ -44,32 +44,130 @@ func (slice PullRequests) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
+// PullRequestGroup holds the pull requests sharing a grouping key,
+// such as a label.
+type PullRequestGroup struct {
+	Name         string // Empty for the pull requests without any key
+	PullRequests []*PullRequest
+}
+
+// groupBy groups the pull requests by the keys returned for each.
+// A pull request with several keys is included in the group of each.
+// Groups are ordered by name, followed by the group of pull requests
+// without any key, if any.
+func groupBy(prs []*PullRequest, keys func(*PullRequest) []string) []*PullRequestGroup {
+	groups := map[string]*PullRequestGroup{}
+	var names []string
+	var none *PullRequestGroup
+	for _, pr := range prs {
+		prKeys := keys(pr)
+		if len(prKeys) == 0 {
+			if none == nil {
+				none = &PullRequestGroup{}
+			}
+			none.PullRequests = append(none.PullRequests, pr)
+		}
+		for _, key := range prKeys {
+			g, ok := groups[key]
+			if !ok {
+				g = &PullRequestGroup{Name: key}
+				groups[key] = g
+				names = append(names, key)
+			}
+			g.PullRequests = append(g.PullRequests, pr)
+		}
+	}
+	sort.Strings(names)
+	result := make([]*PullRequestGroup, 0, len(names)+1)
+	for _, name := range names {
+		result = append(result, groups[name])
+	}
+	if none != nil {
+		result = append(result, none)
+	}
+	return result
+}
+
+// groupByLabel groups the pull requests by label, for templates.
+// Returns no groups if none of the pull requests is labeled, so that
+// repositories which don't use labels get no section of them.
+func groupByLabel(prs []*PullRequest) []*PullRequestGroup {
+	labeled := false
+	for _, pr := range prs {
+		labeled = labeled || len(pr.Labels) > 0
+	}
+	if !labeled {
+		return nil
+	}
+	return groupBy(prs, func(pr *PullRequest) []string {
+		var names []string
+		for _, l := range pr.Labels {
+			names = append(names, l.Name)
+		}
+		return names
+	})
+}
+
+// groupByMilestone groups the pull requests by milestone, for
+// templates.
+func groupByMilestone(prs []*PullRequest) []*PullRequestGroup {
+	return groupBy(prs, func(pr *PullRequest) []string {
+		if pr.Milestone == nil {
+			return nil
+		}
+		return []string{pr.Milestone.Title}
+	})
+}
+
 func markDowner(args ...interface{}) string {
 	return string(github_flavored_markdown.Markdown([]byte(fmt.Sprintf("%s", args...))))
 }
 
-// Digest computes the digest from provided slices of open and
-// closed pull requests.
-func Digest(c *Config, open, closed []*PullRequest) error {
+// Digest computes the digest from provided slices of open, merged and
+// abandoned pull requests and opened and closed issues. Templates may
+// also range over all closed pull requests, merged or abandoned, as
+// .Closed, and check .Issues to omit issue sections when issues
+// weren't queried.
+func Digest(c *Config, open, merged, abandoned []*PullRequest, openedIssues, closedIssues []*Issue) error {
 	sortedOpen := PullRequests(open)
-	sortedClosed := PullRequests(closed)
+	sortedMerged := PullRequests(merged)
+	sortedAbandoned := PullRequests(abandoned)
+	sortedClosed := append(append(PullRequests(nil), merged...), abandoned...)
 	sort.Sort(sortedOpen)
+	sort.Sort(sortedMerged)
+	sort.Sort(sortedAbandoned)
 	sort.Sort(sortedClosed)
+	sort.Sort(Issues(openedIssues))
+	sort.Sort(Issues(closedIssues))
 
 	// Open file for digest HTML.
 	now := time.Now()
 	content := struct {
-		Open   []*PullRequest
-		Closed []*PullRequest
+		Open         []*PullRequest
+		Merged       []*PullRequest
+		Abandoned    []*PullRequest
+		Closed       []*PullRequest
+		Issues       bool
+		OpenedIssues []*Issue
+		ClosedIssues []*Issue
 	}{
-		Open:   sortedOpen,
-		Closed: sortedClosed,
+		Open:         sortedOpen,
+		Merged:       sortedMerged,
+		Abandoned:    sortedAbandoned,
+		Closed:       sortedClosed,
+		Issues:       c.Issues,
+		OpenedIssues: openedIssues,
+		ClosedIssues: closedIssues,
 	}
 	htmlTemplate, err := ioutil.ReadFile(c.Template)
 	if err != nil {
 		return fmt.Errorf("failed to read template file %q: %s", c.Template, err)
 	}
-	tmpl := template.Must(template.New("digest").Funcs(template.FuncMap{"markDown": markDowner}).Parse(string(htmlTemplate)))
+	tmpl := template.Must(template.New("digest").Funcs(template.FuncMap{
+		"markDown":         markDowner,
+		"groupByLabel":     groupByLabel,
+		"groupByMilestone": groupByMilestone,
+	}).Parse(string(htmlTemplate)))
 
 	buf := new(bytes.Buffer)
 	if err := tmpl.Execute(buf, content); err != nil {
@@ -21,16 +21,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"regexp"
+	"net/url"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
-var ctx = context.Background()
-
 // A rateLimitError is returned when the requestor's rate limit has
 // been exceeded.
 type rateLimitError struct {
@@ -52,120 +53,349 @@ func (rle *rateLimitError) expiration() time.Duration {
 
 // An httpError specifies a non-200 http response code.
 type httpError struct {
-	req  *http.Request
-	resp *http.Response
+	req     *http.Request
+	resp    *http.Response
+	message string // Error message from the response body, if any
 }
 
 // Error implements the error interface.
 func (e *httpError) Error() string {
-	return fmt.Sprintf("failed to fetch (req: %s): %s", e.req, e.resp)
+	msg := fmt.Sprintf("failed to fetch %s %s: %s", e.req.Method, e.req.URL, e.resp.Status)
+	if len(e.message) > 0 {
+		msg += fmt.Sprintf(" (%s)", e.message)
+	}
+	return msg
+}
+
+// An authError is returned for 401 (Unauthorized) responses and for
+// 403 (Forbidden) responses not caused by rate limiting. The access
+// token is missing, expired or lacks the necessary permissions.
+type authError struct {
+	*httpError
+}
+
+// Error implements the error interface.
+func (e *authError) Error() string {
+	return e.httpError.Error() + "; check the access token and its permissions"
+}
+
+// A notFoundError is returned for 404 (Not Found) and 410 (Gone)
+// responses, as for a repository which was renamed or made private.
+type notFoundError struct {
+	*httpError
+}
+
+// A serverError is returned for 5xx responses, which are retried.
+type serverError struct {
+	*httpError
+}
+
+// A transientError wraps networking errors and responses indicating
+// that the request should be retried later.
+type transientError struct {
+	err error
+}
+
+// Error implements the error interface.
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+// newHTTPClient returns the HTTP client used for all requests made
+// according to the configuration. Each request is limited to
+// c.RequestTimeout, if non-zero. Responses are saved to c.Record or
+// served from c.Replay if either is specified.
+func newHTTPClient(c *Config) (*http.Client, error) {
+	transport, err := newTransport(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Replay) > 0 {
+		transport = &replayTransport{dir: c.Replay}
+	} else if len(c.Record) > 0 {
+		if err := os.MkdirAll(c.Record, 0755); err != nil {
+			return nil, err
+		}
+		transport = &recordingTransport{dir: c.Record, base: transport}
+	}
+	return &http.Client{Transport: transport, Timeout: c.RequestTimeout}, nil
+}
+
+// token returns the access token authorizing requests made on behalf
+// of the repository specified by the context.
+func (c *Config) token(ctx context.Context) (string, error) {
+	if c.tokens == nil {
+		return c.Token, nil
+	}
+	return c.tokens.token(ctx, repoFromContext(ctx))
 }
 
-// linkRE provides parsing of the "Link" HTTP header directive.
-var linkRE = regexp.MustCompile(`^<(.*)>; rel="next", <(.*)>; rel="last".*`)
+// isGitHubHost returns whether req is made to the host of the GitHub
+// API root.
+func isGitHubHost(c *Config, req *http.Request) bool {
+	u, err := url.Parse(c.Host)
+	return err == nil && u.Host == req.URL.Host
+}
+
+// tokenFor returns the access token authorizing req, made on behalf of
+// the repository specified by the context, and whether req is to be
+// authorized with it. GitHub access tokens are only sent to the GitHub
+// instance they belong to; other forges authorize their own requests.
+func (c *Config) tokenFor(ctx context.Context, req *http.Request) (string, bool, error) {
+	if token, ok := c.hostTokens[req.URL.Host]; ok {
+		return token, true, nil
+	}
+	if !isGitHubHost(c, req) {
+		return "", false, nil
+	}
+	token, err := c.token(ctx)
+	return token, true, err
+}
 
 // fetchURL fetches the specified URL using the HTTP client. Returns
-// the next URL if the result is paged or an error on failure.
-func fetchURL(c *Config, url string, value interface{}) (string, error) {
-	// Create request and add mandatory user agent and accept encoding headers.
+// the pagination links if the result is paged or an error on failure.
+func fetchURL(ctx context.Context, c *Config, url string, value interface{}) (links, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", err
+		return links{}, err
 	}
+	return fetchRequest(ctx, c, req, value)
+}
+
+// A bodyDecoder decodes response bodies which aren't plain JSON. Values
+// passed to fetchRequest which implement it decode the body themselves.
+type bodyDecoder interface {
+	decodeBody(body []byte) error
+}
+
+// fetchRequest performs the request, retrying as necessary, and parses
+// the JSON response body into value. Only GET requests are cached. The
+// request, including any waits for the rate limit to reset or to back
+// off before a retry, is aborted if the context is done.
+func fetchRequest(ctx context.Context, c *Config, req *http.Request, value interface{}) (links, error) {
+	req = req.WithContext(ctx)
+	url := req.URL.String()
+
+	// Add mandatory user agent and accept encoding headers.
 	req.Header.Add("User-Agent", "Repository Digest App")
 	req.Header.Add("Accept-Encoding", "application/json")
-	req.Header.Add("Authorization", fmt.Sprintf("token %s", c.Token))
 	if len(c.acceptHeader) > 0 {
 		req.Header.Add("Accept", c.acceptHeader)
 	}
 
-	var next string
+	// Make the request conditional if a previous response is cached.
+	// Recordings must hold full responses to be replayed without the
+	// cache, so requests aren't made conditional when recording.
+	var cached *recordedResponse
+	var err error
+	if c.cache != nil && req.Method == "GET" && len(c.Record) == 0 {
+		if cached, err = c.cache.get(req); err != nil {
+			return links{}, err
+		}
+		if cached != nil {
+			addConditionalHeaders(req, cached)
+		}
+	}
+
 	var resp *http.Response
+	var token string
+	authorize := len(req.Header.Get("Authorization")) == 0
 
 	// We loop until we have a next URL or we've gotten a direct result
 	// by fetching from the server; the last result might change between
-	// runs, so it must be rechecked. Maximum 10 retries.
+	// runs, so it must be rechecked. Rate limits, server errors and
+	// transient errors are retried, up to 10 attempts in all; any other
+	// error is permanent.
 	for i := uint(0); i < 10; i++ {
+		pace := true
+		if authorize {
+			// The token may change between attempts if rotated.
+			var ok bool
+			if token, ok, err = c.tokenFor(ctx, req); err != nil {
+				return links{}, err
+			}
+			if ok {
+				req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+			}
+			// Rather than pace requests, exhaust the token and rotate
+			// to another.
+			if r, ok := c.tokens.(tokenRotator); ok && isGitHubHost(c, req) && r.spare(token) {
+				pace = false
+			}
+		}
+		endHold := c.stats.hold()
+		waitErr := c.limiter.wait(ctx, req, pace)
+		endHold()
+		if waitErr != nil {
+			return links{}, waitErr
+		}
+		if req.GetBody != nil {
+			// Rewind the request body, consumed by any previous attempt.
+			if req.Body, err = req.GetBody(); err != nil {
+				return links{}, err
+			}
+		}
+		c.stats.request(req, i)
 		resp, err = doFetch(c, url, req)
 		if err == nil {
 			break
 		}
 		switch t := err.(type) {
 		case *rateLimitError:
-			// Sleep until the expiration of the rate limit regime (+ 1s for clock offsets).
+			// Rotate to another token if available; otherwise hold
+			// requests made with the token until the expiration of the
+			// rate limit regime (+ 1s for clock offsets).
+			if r, ok := c.tokens.(tokenRotator); ok && authorize && isGitHubHost(c, req) && r.rotate(token, t) {
+				log.Printf("%s; rotating to next access token\n", t)
+				continue
+			}
 			log.Println(t)
-			time.Sleep(t.expiration())
-		case *httpError:
-			// For now, regard HTTP errors as permanent.
-			log.Printf("unable to fetch %q: %v\n", url, t.resp)
-			return "", nil
-		default:
-			// Retry with exponential backoff on random connection and networking errors.
+			c.limiter.exceeded(req, t)
+		case *secondaryRateLimitError:
+			// Hold requests made with the same credentials for as long
+			// as the server requests.
+			log.Println(t)
+			c.limiter.holdFor(req, t.retryAfter)
+		case *serverError, *transientError:
+			// Retry with exponential backoff on server, connection and networking errors.
 			log.Println(t)
 			backoff := int64((1 << i)) * 50000000 // nanoseconds, starting at 50ms
 			if backoff > 1000000000 {
 				backoff = 1000000000
 			}
-			time.Sleep(time.Duration(backoff))
+			endBackOff := c.stats.backOff()
+			sleepErr := sleep(ctx, time.Duration(backoff))
+			endBackOff()
+			if sleepErr != nil {
+				return links{}, sleepErr
+			}
+		default:
+			// Authorization failures, missing resources, other client
+			// errors and missing recordings won't resolve on retry.
+			return links{}, err
 		}
 	}
 	if resp == nil {
-		log.Printf("unable to fetch %q\n", url)
-		return "", nil
+		return links{}, errors.New(fmt.Sprintf("unable to fetch %q after 10 attempts: %s", url, err))
 	}
 
-	// Parse the next link, if available.
-	if link := resp.Header.Get("Link"); len(link) > 0 {
-		urls := linkRE.FindStringSubmatch(link)
-		if urls != nil {
-			next = urls[1]
+	// Read the body, or take it from the cache if unmodified.
+	var body []byte
+	header := resp.Header
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		if cached == nil {
+			return links{}, errors.New(fmt.Sprintf("304 (Not Modified) for uncached URL=%q", url))
+		}
+		header, body = cached.Header, []byte(cached.Body)
+		c.stats.response(0, true)
+	} else {
+		if body, err = ioutil.ReadAll(resp.Body); err != nil {
+			return links{}, err
+		}
+		c.stats.response(len(body), false)
+		if c.cache != nil && req.Method == "GET" {
+			if err := c.cache.put(req, resp, body); err != nil {
+				return links{}, err
+			}
 		}
 	}
 
 	// Parse the body from JSON string into the supplied go struct.
-	var body []byte
-	defer resp.Body.Close()
-	body, err = ioutil.ReadAll(resp.Body)
+	if bd, ok := value.(bodyDecoder); ok {
+		err = bd.decodeBody(body)
+	} else {
+		err = json.Unmarshal(body, value)
+	}
 	if err != nil {
-		return "", err
+		return links{}, errors.New(fmt.Sprintf("unmarshal URL=%q: %s", url, err))
 	}
-	if err = json.Unmarshal(body, value); err != nil {
-		return "", errors.New(fmt.Sprintf("unmarshal URL=%q: %s", url, err))
+	l := parseLinkHeader(header.Get("Link"))
+	l.Total = -1
+	if total, err := strconv.Atoi(header.Get("X-Total-Count")); err == nil {
+		l.Total = total
 	}
-	return next, nil
+	return l, nil
 }
 
-// doFetch performs the GET https request. A rateLimitError is
-// returned in the event that the access token has exceeded its hourly
-// limit.
+// doFetch performs the https request. A rateLimitError is returned in
+// the event that the access token has exceeded its hourly limit, and a
+// secondaryRateLimitError if requests are being made too quickly. Other
+// failures are classified as an authError, notFoundError, serverError
+// or transientError, or else an httpError. Besides 200, a 201
+// (Created) response and a 304 (Not Modified) response to a
+// conditional request are returned as a success.
 func doFetch(c *Config, url string, req *http.Request) (*http.Response, error) {
-	resp, err := http.DefaultClient.Do(req)
+	client := c.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		var re *replayError
+		if errors.As(err, &re) {
+			return nil, re
+		}
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, &transientError{err}
 	}
+	c.limiter.observe(req, resp.Header)
 	switch resp.StatusCode {
-	case 200:
-		return resp, err
+	case 200, 201, 304:
+		return resp, nil
 	case 202: // Accepted
 		// This is a weird one, but it's been returned by GitHub before.
-		err = errors.New("202 (Accepted) HTTP response; backoff and retry")
-	case 403: // Forbidden...handle case of rate limit exception
+		resp.Body.Close()
+		return nil, &transientError{errors.New("202 (Accepted) HTTP response; backoff and retry")}
+	case 403, 429: // Forbidden or Too Many Requests...handle case of rate limit exception
 		if limitRem := resp.Header.Get("X-rateLimit-Remaining"); len(limitRem) > 0 {
-			var remaining, resetUnix int
-			if remaining, err = strconv.Atoi(limitRem); err == nil && remaining == 0 {
+			if remaining, err := strconv.Atoi(limitRem); err == nil && remaining == 0 {
 				if limitReset := resp.Header.Get("X-rateLimit-Reset"); len(limitReset) > 0 {
-					if resetUnix, err = strconv.Atoi(limitReset); err == nil {
-						err = &rateLimitError{resetUnix: int64(resetUnix)}
+					if resetUnix, err := strconv.Atoi(limitReset); err == nil {
 						resp.Body.Close()
-						return nil, err
+						return nil, &rateLimitError{resetUnix: int64(resetUnix)}
 					}
 				}
 			}
 		}
-		fallthrough
-	default:
-		err = &httpError{req, resp}
 	}
-	resp.Body.Close()
-	return nil, err
+
+	he := newHTTPError(req, resp)
+	if code := resp.StatusCode; code == 403 || code == 429 {
+		// Secondary rate limits are signaled by Retry-After, by a 429 or
+		// otherwise only by the error message.
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			return nil, &secondaryRateLimitError{retryAfter: time.Duration(secs) * time.Second}
+		}
+		if code == 429 || strings.Contains(strings.ToLower(he.message), "secondary rate limit") {
+			return nil, &secondaryRateLimitError{retryAfter: secondaryRateLimitWait}
+		}
+	}
+	switch code := resp.StatusCode; {
+	case code == 401 || code == 403:
+		return nil, &authError{he}
+	case code == 404 || code == 410:
+		return nil, &notFoundError{he}
+	case code >= 500:
+		return nil, &serverError{he}
+	}
+	return nil, he
+}
+
+// newHTTPError returns an httpError for the response, consuming and
+// closing the response body to extract the error message.
+func newHTTPError(req *http.Request, resp *http.Response) *httpError {
+	defer resp.Body.Close()
+	he := &httpError{req: req, resp: resp}
+	var body struct {
+		Message string `json:"message"`
+	}
+	if data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096)); err == nil {
+		if json.Unmarshal(data, &body) == nil {
+			he.message = body.Message
+		}
+	}
+	return he
 }
@@ -0,0 +1,183 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Forge is the interface to a code hosting service from which pull
+// request data is fetched. The query functions consume only this
+// interface, so alternate backends (or in-memory fakes) may be
+// substituted without changes to digest rendering.
+type Forge interface {
+	// ListPullRequests fetches a page of pull requests for repo, ordered
+	// by descending opts.Sort, along with the position of the page
+	// within the listing.
+	ListPullRequests(ctx context.Context, repo string, opts ListOptions) ([]*PullRequest, Page, error)
+	// GetPullRequest fills in detailed information for pr.
+	GetPullRequest(ctx context.Context, pr *PullRequest) error
+	// ListCommits returns the commits comprising pr.
+	ListCommits(ctx context.Context, pr *PullRequest) ([]*Commit, error)
+	// ListFiles returns the files changed by pr.
+	ListFiles(ctx context.Context, pr *PullRequest) ([]*File, error)
+}
+
+// forgeFor returns the forge hosting repo, as named in --repos, and
+// the name of the repository on that forge. Repositories are on GitHub
+// unless prefixed by the kind of forge hosting them, as in
+// "gitlab:group/project". GitHub repositories may be qualified by the
+// hostname of another GitHub instance than --host, including a port,
+// as in "ghe.example.com/team/svc" or "ghe.example.com:8443/team/svc".
+func (c *Config) forgeFor(repo string) (Forge, string, error) {
+	i := strings.IndexByte(repo, ':')
+	if i >= 0 {
+		if f, ok := c.forges[repo[:i]]; ok {
+			return f, repo[i+1:], nil
+		}
+	}
+	if host, name, ok := splitHost(repo); ok {
+		f, ok := c.hostForges[host]
+		if !ok {
+			return nil, "", errors.New(fmt.Sprintf("unknown GitHub host %q for repository %q", host, repo))
+		}
+		return f, name, nil
+	}
+	if i >= 0 {
+		return nil, "", errors.New(fmt.Sprintf("unknown forge %q for repository %q", repo[:i], repo))
+	}
+	return c.Forge, repo, nil
+}
+
+// splitHost splits a GitHub repository qualified by the hostname of
+// its GitHub instance, as in "ghe.example.com/team/svc". A hostname
+// contains a dot or else has a port, as in "localhost:8443/team/svc".
+// Returns false if repo isn't qualified.
+func splitHost(repo string) (host, name string, ok bool) {
+	parts := strings.SplitN(repo, "/", 3)
+	if len(parts) < 3 || (!strings.Contains(parts[0], ".") && !hasPort(parts[0])) {
+		return "", "", false
+	}
+	return parts[0], parts[1] + "/" + parts[2], true
+}
+
+// hasPort returns whether host ends with a port number.
+func hasPort(host string) bool {
+	i := strings.LastIndexByte(host, ':')
+	if i <= 0 || i == len(host)-1 {
+		return false
+	}
+	_, err := strconv.Atoi(host[i+1:])
+	return err == nil
+}
+
+// gitHubAPIRoot returns the REST API root of the GitHub instance at
+// host: api.github.com for github.com, or else the enterprise API path.
+func gitHubAPIRoot(host string) string {
+	if host == "github.com" {
+		return "https://api.github.com/"
+	}
+	return "https://" + host + "/api/v3/"
+}
+
+// newGitHubAPIForge returns the forge for the GitHub instance whose
+// REST API root is host, using the API specified by c.API.
+func newGitHubAPIForge(c *Config, host string) (Forge, error) {
+	switch c.API {
+	case "rest":
+		return newGitHubForge(c, host), nil
+	case "graphql":
+		return newGraphQLForge(c, host), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown --api=%s; use \"rest\" or \"graphql\"", c.API))
+}
+
+// A Searcher is a Forge able to select pull requests using a search
+// query, so that qualifiers such as labels, authors and the base branch
+// narrow the candidates on the server rather than every pull request
+// updated since the start of the digest being listed.
+type Searcher interface {
+	// SearchPullRequests fetches a page of the pull requests of repo
+	// matching query, which includes the repository, ordered by
+	// descending update time.
+	SearchPullRequests(ctx context.Context, repo, query string, opts ListOptions) ([]*PullRequest, Page, error)
+}
+
+// A ReviewLister is a Forge able to list the reviews of pull requests.
+type ReviewLister interface {
+	// ListReviews fetches the reviews of pr in order of submission.
+	ListReviews(ctx context.Context, pr *PullRequest) ([]*Review, error)
+}
+
+// A CheckLister is a Forge able to list the CI checks of the head
+// commits of pull requests.
+type CheckLister interface {
+	// ListChecks fetches the latest result of each CI check of the head
+	// commit of pr.
+	ListChecks(ctx context.Context, pr *PullRequest) ([]*Check, error)
+}
+
+// An IssueLister is a Forge able to list the issues of repositories.
+type IssueLister interface {
+	// ListIssues fetches a page of the issues of repo, excluding pull
+	// requests, in descending order of opts.Sort ("updated" only).
+	ListIssues(ctx context.Context, repo string, opts ListOptions) ([]*Issue, Page, error)
+}
+
+// A CloserFinder is a Forge able to find who closed a pull request
+// without merging it, where that isn't reported with the pull request.
+type CloserFinder interface {
+	// FindCloser returns the user who most recently closed pr.
+	FindCloser(ctx context.Context, pr *PullRequest) (User, error)
+}
+
+// A Releaser is a Forge which retains data listed with pull requests
+// until their details are queried.
+type Releaser interface {
+	// Release discards the data retained for pr, once its details have
+	// been queried or if they won't be.
+	Release(pr *PullRequest)
+}
+
+// ListOptions specifies a page of a pull request listing.
+type ListOptions struct {
+	Sort  string    // "updated" or "created"; results are in descending order
+	Since time.Time // Only pull requests whose sort key is after Since are needed
+	Page  string    // Token returned by a previous call; empty for the first page
+	Count bool      // Only dates, state and labels are needed, to count pull requests
+}
+
+// Page describes a page of results within a paginated listing.
+type Page struct {
+	Next  string // Token for the next page; empty if this is the last page
+	Index int    // 1-based index of this page, or 0 if unknown
+	Count int    // Total number of pages, or 0 if unknown
+}
+
+// String returns the position of the page for progress output, e.g.
+// "page 3 of 12".
+func (p Page) String() string {
+	if p.Count > 0 {
+		return fmt.Sprintf("page %d of %d", p.Index, p.Count)
+	}
+	return fmt.Sprintf("page %d", p.Index)
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import "testing"
+
+func TestForgeFor(t *testing.T) {
+	github, ghe, local, gitlab := &gitHubForge{}, &gitHubForge{}, &gitHubForge{}, &gitLabForge{}
+	c := &Config{
+		Forge:  github,
+		forges: map[string]Forge{"gitlab": gitlab},
+		hostForges: map[string]Forge{
+			"ghe.example.com:8443": ghe,
+			"localhost:8765":       local,
+		},
+	}
+	testCases := []struct {
+		repo  string
+		forge Forge
+		name  string
+	}{
+		{"cockroachdb/cockroach", github, "cockroachdb/cockroach"},
+		{"gitlab:group/sub/project", gitlab, "group/sub/project"},
+		{"ghe.example.com:8443/team/svc", ghe, "team/svc"},
+		{"localhost:8765/team/svc", local, "team/svc"},
+	}
+	for _, tc := range testCases {
+		forge, name, err := c.forgeFor(tc.repo)
+		if err != nil {
+			t.Errorf("%s: %s", tc.repo, err)
+			continue
+		}
+		if forge != tc.forge || name != tc.name {
+			t.Errorf("%s: expected %q on %p; got %q on %p", tc.repo, tc.name, tc.forge, name, forge)
+		}
+	}
+
+	for _, repo := range []string{"bogus:team/svc", "ghe:8443/team/svc"} {
+		if _, _, err := c.forgeFor(repo); err == nil {
+			t.Errorf("%s: expected an error", repo)
+		}
+	}
+}
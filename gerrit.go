@@ -0,0 +1,347 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gerritTimeFormat is the format of Gerrit timestamps, which are UTC.
+const gerritTimeFormat = "2006-01-02 15:04:05.000000000"
+
+// gerritAccount is a Gerrit account as embedded in changes.
+type gerritAccount struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Avatars  []struct {
+		URL string `json:"url"`
+	} `json:"avatars"`
+}
+
+func (a *gerritAccount) toUser() User {
+	if a == nil {
+		return User{}
+	}
+	u := User{Login: a.Username, Name: a.Name, Email: a.Email}
+	if len(u.Login) == 0 {
+		u.Login = a.Name
+	}
+	if len(a.Avatars) > 0 {
+		u.AvatarURL = a.Avatars[0].URL
+	}
+	return u
+}
+
+// gerritChange is a Gerrit change, as listed or fetched.
+type gerritChange struct {
+	Project           string         `json:"project"`
+	Number            int            `json:"_number"`
+	Subject           string         `json:"subject"`
+	Status            string         `json:"status"` // "NEW", "MERGED" or "ABANDONED"
+	Created           string         `json:"created"`
+	Updated           string         `json:"updated"`
+	Submitted         string         `json:"submitted"`
+	Insertions        int            `json:"insertions"`
+	Deletions         int            `json:"deletions"`
+	TotalCommentCount int            `json:"total_comment_count"`
+	Hashtags          []string       `json:"hashtags"`
+	Owner             *gerritAccount `json:"owner"`
+	Submitter         *gerritAccount `json:"submitter"`
+	MoreChanges       bool           `json:"_more_changes"` // Set on the last change of a page
+}
+
+// gerritFileInfo is a file modified by a revision of a change.
+type gerritFileInfo struct {
+	Status        string `json:"status"` // "A", "D", "R", "C", "W" or empty if modified
+	LinesInserted int    `json:"lines_inserted"`
+	LinesDeleted  int    `json:"lines_deleted"`
+}
+
+// gerritForge implements Forge using the Gerrit REST API, mapping
+// changes onto pull requests: new changes are open, and merged and
+// abandoned changes are closed. A change comprises the commit of its
+// current revision. Repositories are Gerrit project names and page
+// tokens have the form <index>:<offset>.
+type gerritForge struct {
+	c        *Config
+	host     string // Root URL, e.g. https://review.example.com/
+	user     string // Username for HTTP basic authentication; empty for anonymous access
+	password string // HTTP password (as generated in the user's settings)
+}
+
+func newGerritForge(c *Config, host, user, password string) *gerritForge {
+	if len(host) > 0 && !strings.HasSuffix(host, "/") {
+		host += "/"
+	}
+	return &gerritForge{c: c, host: host, user: user, password: password}
+}
+
+// apiURL returns the URL of the REST endpoint at path. Authenticated
+// requests are made under the "/a/" prefix.
+func (gr *gerritForge) apiURL(path string) string {
+	if len(gr.user) > 0 {
+		return gr.host + "a/" + path
+	}
+	return gr.host + path
+}
+
+// fetch fetches the specified URL, authorized with the Gerrit
+// credentials.
+func (gr *gerritForge) fetch(ctx context.Context, url string, value interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if len(gr.user) > 0 {
+		req.SetBasicAuth(gr.user, gr.password)
+	}
+	_, err = fetchRequest(ctx, gr.c, req, gerritResponse{value})
+	return err
+}
+
+// gerritXSSIPrefix is the line with which Gerrit prefixes JSON
+// responses, guarding against cross-site script inclusion.
+const gerritXSSIPrefix = ")]}'\n"
+
+// gerritResponse decodes a Gerrit JSON response into value.
+type gerritResponse struct {
+	value interface{}
+}
+
+// decodeBody implements the bodyDecoder interface.
+func (r gerritResponse) decodeBody(body []byte) error {
+	return json.Unmarshal(bytes.TrimPrefix(body, []byte(gerritXSSIPrefix)), r.value)
+}
+
+// ListPullRequests implements the Forge interface. Gerrit orders
+// changes only by update time, so when sorted by creation time all
+// changes updated since opts.Since (which include all those created
+// since) are returned as a single page.
+func (gr *gerritForge) ListPullRequests(ctx context.Context, project string, opts ListOptions) ([]*PullRequest, Page, error) {
+	index, offset := 1, 0
+	if len(opts.Page) > 0 {
+		if _, err := fmt.Sscanf(opts.Page, "%d:%d", &index, &offset); err != nil {
+			return nil, Page{}, errors.New(fmt.Sprintf("invalid page token %q", opts.Page))
+		}
+	}
+	query := fmt.Sprintf("project:%s after:\"%s\"", project, opts.Since.UTC().Format("2006-01-02 15:04:05"))
+	prs := []*PullRequest{}
+	for {
+		changes := []*gerritChange{}
+		u := gr.apiURL(fmt.Sprintf("changes/?q=%s&o=DETAILED_ACCOUNTS&n=%d&S=%d", url.QueryEscape(query), perPage, offset))
+		if err := gr.fetch(ctx, u, &changes); err != nil {
+			return nil, Page{}, err
+		}
+		for _, change := range changes {
+			pr := &PullRequest{}
+			change.fill(pr, gr)
+			prs = append(prs, pr)
+		}
+		offset += len(changes)
+		more := len(changes) > 0 && changes[len(changes)-1].MoreChanges
+		if opts.Sort != "created" {
+			page := Page{Index: index}
+			if more {
+				page.Next = fmt.Sprintf("%d:%d", index+1, offset)
+			} else {
+				page.Count = index
+			}
+			return prs, page, nil
+		}
+		if !more {
+			break
+		}
+	}
+	sort.SliceStable(prs, func(i, j int) bool {
+		return mustParseTime3339(prs[i].CreatedAt).After(mustParseTime3339(prs[j].CreatedAt))
+	})
+	return prs, Page{Index: 1, Count: 1}, nil
+}
+
+// gerritTime converts a Gerrit timestamp to RFC 3339, returning the
+// empty string for a missing timestamp.
+func gerritTime(s string) string {
+	t, err := time.Parse(gerritTimeFormat, s)
+	if err != nil {
+		return s
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// fill maps the change onto pr.
+func (change *gerritChange) fill(pr *PullRequest, gr *gerritForge) {
+	id := fmt.Sprintf("%s~%d", url.PathEscape(change.Project), change.Number)
+	pr.URL = gr.apiURL("changes/" + id)
+	pr.HtmlURL = fmt.Sprintf("%sc/%s/+/%d", gr.host, change.Project, change.Number)
+	pr.Number = change.Number
+	pr.ID = change.Number
+	pr.Title = change.Subject
+	pr.User = change.Owner.toUser()
+	pr.CreatedAt = gerritTime(change.Created)
+	pr.UpdatedAt = gerritTime(change.Updated)
+	pr.Additions = change.Insertions
+	pr.Deletions = change.Deletions
+	pr.Comments = change.TotalCommentCount
+	// Hashtags serve Gerrit users as labels do GitHub users.
+	pr.Labels = nil
+	for _, tag := range change.Hashtags {
+		pr.Labels = append(pr.Labels, &Label{Name: tag})
+	}
+	pr.State = "open"
+	switch change.Status {
+	case "MERGED":
+		pr.State = "closed"
+		pr.Merged = true
+		pr.MergedBy = change.Submitter.toUser()
+		pr.MergedAt = gerritTime(change.Submitted)
+		pr.ClosedAt = pr.MergedAt
+	case "ABANDONED":
+		// Gerrit doesn't record when a change was abandoned; it's most
+		// likely the last update.
+		pr.State = "closed"
+		pr.ClosedAt = pr.UpdatedAt
+	}
+}
+
+// GetPullRequest implements the Forge interface.
+func (gr *gerritForge) GetPullRequest(ctx context.Context, pr *PullRequest) error {
+	change := &gerritChange{}
+	if err := gr.fetch(ctx, pr.URL+"?o=DETAILED_ACCOUNTS", change); err != nil {
+		return err
+	}
+	change.fill(pr, gr)
+	return nil
+}
+
+// ListReviews implements the ReviewLister interface, listing the
+// Code-Review votes on the change in order of voting: +2 approves,
+// negative votes request changes and +1 comments. Reviewers who haven't
+// voted are omitted.
+func (gr *gerritForge) ListReviews(ctx context.Context, pr *PullRequest) ([]*Review, error) {
+	var change struct {
+		Labels map[string]struct {
+			All []struct {
+				gerritAccount
+				Value int    `json:"value"`
+				Date  string `json:"date"`
+			} `json:"all"`
+		} `json:"labels"`
+	}
+	if err := gr.fetch(ctx, pr.URL+"?o=DETAILED_LABELS&o=DETAILED_ACCOUNTS", &change); err != nil {
+		return nil, err
+	}
+	reviews := []*Review{}
+	for _, vote := range change.Labels["Code-Review"].All {
+		if vote.Value == 0 {
+			continue
+		}
+		r := &Review{User: vote.gerritAccount.toUser(), State: reviewCommented, SubmittedAt: gerritTime(vote.Date)}
+		if vote.Value >= 2 {
+			r.State = reviewApproved
+		} else if vote.Value < 0 {
+			r.State = reviewChangesRequested
+		}
+		reviews = append(reviews, r)
+	}
+	sort.SliceStable(reviews, func(i, j int) bool { return reviews[i].SubmittedAt < reviews[j].SubmittedAt })
+	return reviews, nil
+}
+
+// FindCloser implements the CloserFinder interface, returning the
+// author of the last message recording that the change was abandoned.
+func (gr *gerritForge) FindCloser(ctx context.Context, pr *PullRequest) (User, error) {
+	var change struct {
+		Messages []struct {
+			Tag    string         `json:"tag"`
+			Author *gerritAccount `json:"author"`
+		} `json:"messages"`
+	}
+	if err := gr.fetch(ctx, pr.URL+"?o=MESSAGES&o=DETAILED_ACCOUNTS", &change); err != nil {
+		return User{}, err
+	}
+	var closer User
+	for _, m := range change.Messages {
+		if m.Tag == "autogenerated:gerrit:abandon" {
+			closer = m.Author.toUser()
+		}
+	}
+	return closer, nil
+}
+
+// ListCommits implements the Forge interface, returning the commit of
+// the change's current revision. Its message is the change's
+// description, which becomes the body of the pull request.
+func (gr *gerritForge) ListCommits(ctx context.Context, pr *PullRequest) ([]*Commit, error) {
+	var info struct {
+		Commit  string `json:"commit"`
+		Message string `json:"message"`
+	}
+	if err := gr.fetch(ctx, pr.URL+"/revisions/current/commit", &info); err != nil {
+		return nil, err
+	}
+	c := &Commit{SHA: info.Commit}
+	c.Commit.Message = strings.TrimSpace(info.Message)
+	_, pr.Body = splitMessage(info.Message)
+	pr.Commits = 1
+	return []*Commit{c}, nil
+}
+
+// ListFiles implements the Forge interface, listing the files modified
+// by the change's current revision.
+func (gr *gerritForge) ListFiles(ctx context.Context, pr *PullRequest) ([]*File, error) {
+	infos := map[string]*gerritFileInfo{}
+	if err := gr.fetch(ctx, pr.URL+"/revisions/current/files", &infos); err != nil {
+		return nil, err
+	}
+	files := []*File{}
+	for path, info := range infos {
+		// Skip the magic files holding the commit message and the list
+		// of commits merged by a merge commit.
+		if strings.HasPrefix(path, "/") {
+			continue
+		}
+		f := &File{
+			Filename:  path,
+			Status:    "modified",
+			Additions: info.LinesInserted,
+			Deletions: info.LinesDeleted,
+			Changes:   info.LinesInserted + info.LinesDeleted,
+		}
+		switch info.Status {
+		case "A", "C":
+			f.Status = "added"
+		case "D":
+			f.Status = "removed"
+		case "R":
+			f.Status = "renamed"
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+	pr.ChangedFiles = len(files)
+	return files, nil
+}
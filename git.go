@@ -0,0 +1,245 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyTree is the ID of git's empty tree, against which the changes of
+// a root commit are computed.
+const emptyTree = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+
+var (
+	// mergeRegexp matches the subject of GitHub's merge commits.
+	mergeRegexp = regexp.MustCompile(`^Merge pull request #([0-9]+) from `)
+	// squashRegexp matches the pull request number GitHub appends to the
+	// subject of squashed and rebased commits.
+	squashRegexp = regexp.MustCompile(` \(#([0-9]+)\)$`)
+)
+
+// gitForge implements Forge using a local clone, for digests made
+// without API access. Each commit on the first-parent history of HEAD
+// (the default branch of a fresh clone or mirror) is a closed, merged
+// pull request: a merge commit comprises the commits it merged, and
+// any other commit only itself. Titles and bodies are taken from the
+// commit messages and changed files from the diff against the first
+// parent. There are no open pull requests, and no web pages to link
+// to (HtmlURL is empty). Repositories are the paths of local clones.
+type gitForge struct {
+	c *Config
+}
+
+func newGitForge(c *Config) *gitForge {
+	return &gitForge{c: c}
+}
+
+// git runs git in the repository dir, returning its output.
+func git(ctx context.Context, dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", errors.New(fmt.Sprintf("git %s in %s failed: %s: %s",
+			strings.Join(args, " "), dir, err, strings.TrimSpace(stderr.String())))
+	}
+	return string(out), nil
+}
+
+// gitLogFormat formats each commit as fields separated by the ASCII
+// unit separator and terminated by the record separator: hash, author
+// name, email and date, committer name and date, and the raw message.
+const gitLogFormat = "--format=%H%x1f%an%x1f%ae%x1f%aI%x1f%cn%x1f%cI%x1f%B%x1e"
+
+// ListPullRequests implements the Forge interface. All commits since
+// opts.Since are returned as a single page.
+func (g *gitForge) ListPullRequests(ctx context.Context, dir string, opts ListOptions) ([]*PullRequest, Page, error) {
+	out, err := git(ctx, dir, "log", "--first-parent", "--since="+opts.Since.Format(time.RFC3339), gitLogFormat, "HEAD")
+	if err != nil {
+		return nil, Page{}, err
+	}
+	prs := []*PullRequest{}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x1f", 7)
+		if len(fields) < 7 {
+			continue
+		}
+		pr := &PullRequest{
+			URL:            fields[0],
+			MergeCommitSHA: fields[0],
+			State:          "closed",
+			Merged:         true,
+			User:           User{Login: fields[1], Name: fields[1], Email: fields[2]},
+			CreatedAt:      fields[3],
+			MergedBy:       User{Login: fields[4], Name: fields[4]},
+			UpdatedAt:      fields[5],
+			ClosedAt:       fields[5],
+			MergedAt:       fields[5],
+		}
+		subject, body := splitMessage(fields[6])
+		if m := mergeRegexp.FindStringSubmatch(subject); m != nil {
+			// GitHub's merge commits carry the title as the body's first line.
+			pr.Number, _ = strconv.Atoi(m[1])
+			if title, rest := splitMessage(body); len(title) > 0 {
+				subject, body = title, rest
+			}
+		} else if m := squashRegexp.FindStringSubmatch(subject); m != nil {
+			pr.Number, _ = strconv.Atoi(m[1])
+			subject = strings.TrimSuffix(subject, m[0])
+		}
+		pr.Title, pr.Body = subject, body
+		prs = append(prs, pr)
+	}
+
+	key := func(pr *PullRequest) string { return pr.UpdatedAt }
+	if opts.Sort == "created" {
+		key = func(pr *PullRequest) string { return pr.CreatedAt }
+	}
+	sort.SliceStable(prs, func(i, j int) bool {
+		return mustParseTime3339(key(prs[i])).After(mustParseTime3339(key(prs[j])))
+	})
+	return prs, Page{Index: 1, Count: 1}, nil
+}
+
+// splitMessage splits a commit message into its subject (the first
+// line) and the remainder, trimmed of surrounding blank lines.
+func splitMessage(msg string) (subject, body string) {
+	msg = strings.TrimSpace(msg)
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		return strings.TrimSpace(msg[:i]), strings.TrimSpace(msg[i+1:])
+	}
+	return msg, ""
+}
+
+// GetPullRequest implements the Forge interface. All information is
+// listed by ListPullRequests, except for what ListCommits and ListFiles
+// derive.
+func (g *gitForge) GetPullRequest(ctx context.Context, pr *PullRequest) error {
+	return nil
+}
+
+// parents returns the parents of the pull request's commit.
+func (g *gitForge) parents(ctx context.Context, dir, sha string) ([]string, error) {
+	out, err := git(ctx, dir, "rev-list", "--parents", "-n", "1", sha)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out)[1:], nil
+}
+
+// ListCommits implements the Forge interface. A merge commit comprises
+// the commits reachable from its second parent but not its first.
+func (g *gitForge) ListCommits(ctx context.Context, pr *PullRequest) ([]*Commit, error) {
+	_, dir, err := g.c.forgeFor(pr.Repo)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := g.parents(ctx, dir, pr.MergeCommitSHA)
+	if err != nil {
+		return nil, err
+	}
+	revs := []string{"-n", "1", pr.MergeCommitSHA}
+	if len(parents) > 1 {
+		revs = []string{parents[0] + ".." + parents[1]}
+	}
+	out, err := git(ctx, dir, append([]string{"log", "--format=%H%x1f%B%x1e"}, revs...)...)
+	if err != nil {
+		return nil, err
+	}
+	commits := []*Commit{}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimLeft(record, "\n"), "\x1f", 2)
+		if len(fields) < 2 {
+			continue
+		}
+		c := &Commit{SHA: fields[0]}
+		c.Commit.Message = strings.TrimSpace(fields[1])
+		commits = append(commits, c)
+	}
+	pr.Commits = len(commits)
+	return commits, nil
+}
+
+// ListFiles implements the Forge interface, listing the files changed
+// relative to the first parent of the pull request's commit. The line
+// counts are totaled in pr.Additions and pr.Deletions; binary files
+// count no lines.
+func (g *gitForge) ListFiles(ctx context.Context, pr *PullRequest) ([]*File, error) {
+	_, dir, err := g.c.forgeFor(pr.Repo)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := g.parents(ctx, dir, pr.MergeCommitSHA)
+	if err != nil {
+		return nil, err
+	}
+	base := emptyTree
+	if len(parents) > 0 {
+		base = parents[0]
+	}
+	// Paths are NUL-terminated with -z, rather than quoted if unusual.
+	out, err := git(ctx, dir, "diff", "-z", "--name-status", "--no-renames", base, pr.MergeCommitSHA)
+	if err != nil {
+		return nil, err
+	}
+	// Each file is listed as its status letter followed by its path.
+	statuses := map[string]string{}
+	for fields := strings.Split(out, "\x00"); len(fields) >= 2; fields = fields[2:] {
+		switch fields[0] {
+		case "A":
+			statuses[fields[1]] = "added"
+		case "D":
+			statuses[fields[1]] = "removed"
+		}
+	}
+	if out, err = git(ctx, dir, "diff", "-z", "--numstat", "--no-renames", base, pr.MergeCommitSHA); err != nil {
+		return nil, err
+	}
+	files := []*File{}
+	pr.Additions, pr.Deletions = 0, 0
+	for _, record := range strings.Split(out, "\x00") {
+		fields := strings.SplitN(record, "\t", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		f := &File{Filename: fields[2], Status: "modified"}
+		if status, ok := statuses[f.Filename]; ok {
+			f.Status = status
+		}
+		f.Additions, _ = strconv.Atoi(fields[0])
+		f.Deletions, _ = strconv.Atoi(fields[1])
+		f.Changes = f.Additions + f.Deletions
+		pr.Additions += f.Additions
+		pr.Deletions += f.Deletions
+		files = append(files, f)
+	}
+	pr.ChangedFiles = len(files)
+	return files, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGitListFilesUnusualPaths(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir, err := ioutil.TempDir("", "repo-digest-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	run := func(args ...string) string {
+		args = append([]string{"-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)
+		out, err := git(ctx, dir, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(out)
+	}
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	write("main.go", "package main\n")
+	write("old.txt", "old\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "first")
+	write("main.go", "package main\n\nfunc main() {}\n")
+	write("docs/café.md", "bonjour\n")
+	write("tab\tname.txt", "tab\n")
+	run("rm", "-q", "old.txt")
+	run("add", "-A")
+	run("commit", "-q", "-m", "second")
+
+	c := &Config{}
+	g := newGitForge(c)
+	c.forges = map[string]Forge{"git": g}
+	pr := &PullRequest{Repo: "git:" + dir, MergeCommitSHA: run("rev-parse", "HEAD")}
+	files, err := g.ListFiles(ctx, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range files {
+		got[f.Filename] = f.Status
+	}
+	expected := map[string]string{
+		"main.go":       "modified",
+		"docs/café.md":  "added",
+		"tab\tname.txt": "added",
+		"old.txt":       "removed",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q; got %q", expected, got)
+	}
+	if pr.Additions != 4 || pr.Deletions != 1 {
+		t.Errorf("expected 4 additions and 1 deletion; got %d and %d", pr.Additions, pr.Deletions)
+	}
+}
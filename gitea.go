@@ -0,0 +1,308 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// giteaPageSize is the number of items requested per page; Gitea's
+// default maximum (MAX_RESPONSE_ITEMS) is 50.
+const giteaPageSize = 50
+
+// giteaReview is a review of a pull request. Unlike GitHub, Gitea
+// reports dismissal separately from the state.
+type giteaReview struct {
+	ID          int    `json:"id"`
+	User        User   `json:"user"`
+	State       string `json:"state"` // "APPROVED", "REQUEST_CHANGES", "COMMENT", "PENDING" or "REQUEST_REVIEW"
+	Dismissed   bool   `json:"dismissed"`
+	SubmittedAt string `json:"submitted_at"`
+	HtmlURL     string `json:"html_url"`
+}
+
+// giteaForge implements Forge using the Gitea API (v1), which Forgejo
+// shares. Its representation of pull requests, commits and files
+// follows GitHub's, except that a pull request's "url" is its web page.
+// Pages are requested by number, with the total number of items taken
+// from the "X-Total-Count" response header rather than "Link" headers,
+// or else listings end with an empty page.
+type giteaForge struct {
+	c     *Config
+	host  string // API root, e.g. https://gitea.example.com/api/v1/
+	token string // Access token; may be empty
+}
+
+func newGiteaForge(c *Config, host, token string) *giteaForge {
+	if len(host) > 0 && !strings.HasSuffix(host, "/") {
+		host += "/"
+	}
+	return &giteaForge{c: c, host: host, token: token}
+}
+
+// fetch fetches page (1-based) of the listing at url, or url itself if
+// page is 0, authorized with the Gitea token. Returns the total number
+// of items in the listing, or -1 if not reported.
+func (gt *giteaForge) fetch(ctx context.Context, url string, page int, value interface{}) (int, error) {
+	if page > 0 {
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		url = fmt.Sprintf("%s%spage=%d&limit=%d", url, sep, page, giteaPageSize)
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(gt.token) > 0 {
+		req.Header.Set("Authorization", "token "+gt.token)
+	}
+	links, err := fetchRequest(ctx, gt.c, req, value)
+	return links.Total, err
+}
+
+// pages returns the number of pages needed to list n items, at least
+// one.
+func pages(n, perPage int) int {
+	if n <= perPage {
+		return 1
+	}
+	return (n + perPage - 1) / perPage
+}
+
+// giteaMore returns whether a listing of total items continues after a
+// page of n items, seen items having been listed up to and including
+// the page. If the total isn't reported (as when stripped by a proxy),
+// the listing ends with an empty page: the server may cap the page size
+// below giteaPageSize, so a short page needn't be the last.
+func giteaMore(n, seen, total int) bool {
+	if total < 0 {
+		return n > 0
+	}
+	return n > 0 && seen < total
+}
+
+// giteaPage returns the position of page within a listing of total
+// items, of which seen preceded the page and n were on it. The server
+// may cap the page size below giteaPageSize, so the remaining pages
+// are estimated from the size of this one. The number of pages is
+// unknown if the total isn't reported.
+func giteaPage(page, seen, n, total int) Page {
+	p := Page{Index: page, Count: page}
+	if total < 0 {
+		p.Count = 0
+	}
+	if giteaMore(n, seen+n, total) {
+		if total >= 0 {
+			p.Count += pages(total-seen-n, n)
+		}
+		p.Next = fmt.Sprintf("%d:%d", page+1, seen+n)
+	}
+	return p
+}
+
+// ListPullRequests implements the Forge interface. Page tokens have the
+// form <index>:<offset>, the offset being the number of pull requests
+// on previous pages.
+func (gt *giteaForge) ListPullRequests(ctx context.Context, repo string, opts ListOptions) ([]*PullRequest, Page, error) {
+	page, seen := 1, 0
+	if len(opts.Page) > 0 {
+		if _, err := fmt.Sscanf(opts.Page, "%d:%d", &page, &seen); err != nil {
+			return nil, Page{}, errors.New(fmt.Sprintf("invalid page token %q", opts.Page))
+		}
+	}
+	// Pull requests are listed newest first unless sorted otherwise.
+	url := fmt.Sprintf("%srepos/%s/pulls?state=all", gt.host, repo)
+	if opts.Sort == "updated" {
+		url += "&sort=recentupdate"
+	}
+	fetched := []*PullRequest{}
+	total, err := gt.fetch(ctx, url, page, &fetched)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	for _, pr := range fetched {
+		pr.URL = fmt.Sprintf("%srepos/%s/pulls/%d", gt.host, repo, pr.Number)
+	}
+	return fetched, giteaPage(page, seen, len(fetched), total), nil
+}
+
+// ListIssues implements the IssueLister interface. Issues updated
+// before opts.Since are filtered on the server, and pull requests are
+// excluded. Page tokens have the same form as for ListPullRequests.
+func (gt *giteaForge) ListIssues(ctx context.Context, repo string, opts ListOptions) ([]*Issue, Page, error) {
+	page, seen := 1, 0
+	if len(opts.Page) > 0 {
+		if _, err := fmt.Sscanf(opts.Page, "%d:%d", &page, &seen); err != nil {
+			return nil, Page{}, errors.New(fmt.Sprintf("invalid page token %q", opts.Page))
+		}
+	}
+	url := fmt.Sprintf("%srepos/%s/issues?state=all&type=issues&since=%s",
+		gt.host, repo, opts.Since.UTC().Format(time.RFC3339))
+	fetched := []*Issue{}
+	total, err := gt.fetch(ctx, url, page, &fetched)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	return fetched, giteaPage(page, seen, len(fetched), total), nil
+}
+
+// GetPullRequest implements the Forge interface.
+func (gt *giteaForge) GetPullRequest(ctx context.Context, pr *PullRequest) error {
+	url := pr.URL
+	if _, err := gt.fetch(ctx, url, 0, pr); err != nil {
+		return err
+	}
+	pr.URL = url
+	return nil
+}
+
+// ListCommits implements the Forge interface. All pages are followed.
+func (gt *giteaForge) ListCommits(ctx context.Context, pr *PullRequest) ([]*Commit, error) {
+	commits := []*Commit{}
+	for page := 1; ; page++ {
+		fetched := []*Commit{}
+		total, err := gt.fetch(ctx, pr.URL+"/commits", page, &fetched)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, fetched...)
+		if !giteaMore(len(fetched), len(commits), total) {
+			return commits, nil
+		}
+	}
+}
+
+// ListFiles implements the Forge interface (requires Gitea 1.19 or
+// later). All pages are followed, up to the same limit as for GitHub.
+func (gt *giteaForge) ListFiles(ctx context.Context, pr *PullRequest) ([]*File, error) {
+	files := []*File{}
+	for page := 1; len(files) < maxFiles; page++ {
+		fetched := []*File{}
+		total, err := gt.fetch(ctx, pr.URL+"/files", page, &fetched)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fetched...)
+		if !giteaMore(len(fetched), len(files), total) {
+			break
+		}
+	}
+	return files, nil
+}
+
+// ListReviews implements the ReviewLister interface. All pages are
+// followed. Requests for review, which Gitea lists as reviews, are
+// omitted.
+func (gt *giteaForge) ListReviews(ctx context.Context, pr *PullRequest) ([]*Review, error) {
+	reviews := []*Review{}
+	for page, seen := 1, 0; ; page++ {
+		fetched := []*giteaReview{}
+		total, err := gt.fetch(ctx, pr.URL+"/reviews", page, &fetched)
+		if err != nil {
+			return nil, err
+		}
+		seen += len(fetched)
+		for _, gr := range fetched {
+			r := &Review{ID: gr.ID, User: gr.User, SubmittedAt: gr.SubmittedAt, HtmlURL: gr.HtmlURL}
+			switch {
+			case gr.Dismissed:
+				r.State = reviewDismissed
+			case gr.State == "APPROVED":
+				r.State = reviewApproved
+			case gr.State == "REQUEST_CHANGES":
+				r.State = reviewChangesRequested
+			case gr.State == "COMMENT":
+				r.State = reviewCommented
+			case gr.State == "PENDING":
+				r.State = reviewPending
+			default:
+				continue
+			}
+			reviews = append(reviews, r)
+		}
+		if !giteaMore(len(fetched), seen, total) {
+			return reviews, nil
+		}
+	}
+}
+
+// ListChecks implements the CheckLister interface, listing the latest
+// commit status of each context for the head commit. Warnings count as
+// successful.
+func (gt *giteaForge) ListChecks(ctx context.Context, pr *PullRequest) ([]*Check, error) {
+	_, name, err := gt.c.forgeFor(pr.Repo)
+	if err != nil {
+		return nil, err
+	}
+	var combined struct {
+		Statuses []struct {
+			Context   string `json:"context"`
+			Status    string `json:"status"` // "pending", "success", "error", "failure" or "warning"
+			TargetURL string `json:"target_url"`
+		} `json:"statuses"`
+	}
+	if _, err := gt.fetch(ctx, fmt.Sprintf("%srepos/%s/commits/%s/status", gt.host, name, pr.Head.SHA), 0, &combined); err != nil {
+		return nil, err
+	}
+	checks := []*Check{}
+	for _, s := range combined.Statuses {
+		ch := &Check{Name: s.Context, State: statusState(s.Status), URL: s.TargetURL}
+		if s.Status == "warning" {
+			ch.State = checkSuccess
+		}
+		checks = append(checks, ch)
+	}
+	return checks, nil
+}
+
+// FindCloser implements the CloserFinder interface, returning the user
+// of the last "close" event on the timeline of the pull request
+// (requires Gitea 1.15 or later). All pages are followed.
+func (gt *giteaForge) FindCloser(ctx context.Context, pr *PullRequest) (User, error) {
+	_, name, err := gt.c.forgeFor(pr.Repo)
+	if err != nil {
+		return User{}, err
+	}
+	var closer User
+	url := fmt.Sprintf("%srepos/%s/issues/%d/timeline", gt.host, name, pr.Number)
+	for page, seen := 1, 0; ; page++ {
+		fetched := []*struct {
+			Type string `json:"type"`
+			User User   `json:"user"`
+		}{}
+		total, err := gt.fetch(ctx, url, page, &fetched)
+		if err != nil {
+			return User{}, err
+		}
+		seen += len(fetched)
+		for _, e := range fetched {
+			if e.Type == "close" {
+				closer = e.User
+			}
+		}
+		if !giteaMore(len(fetched), seen, total) {
+			return closer, nil
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCommits is the most commits GitHub lists for a pull request.
+	maxCommits = 250
+	// maxFiles is the most files GitHub lists for a pull request.
+	maxFiles = 3000
+	// perPage is the page size requested for commits and files.
+	perPage = 100
+)
+
+// gitHubForge implements Forge using the GitHub REST API (v3). Page
+// tokens are the URLs parsed from the "Link" response header.
+type gitHubForge struct {
+	c    *Config
+	host string // API root, e.g. https://api.github.com/
+}
+
+func newGitHubForge(c *Config, host string) *gitHubForge {
+	return &gitHubForge{c: c, host: host}
+}
+
+// ListPullRequests implements the Forge interface.
+func (gh *gitHubForge) ListPullRequests(ctx context.Context, repo string, opts ListOptions) ([]*PullRequest, Page, error) {
+	url := opts.Page
+	if len(url) == 0 {
+		url = fmt.Sprintf("%srepos/%s/pulls?state=all&sort=%s&direction=desc", gh.host, repo, opts.Sort)
+	}
+	fetched := []*PullRequest{}
+	links, err := fetchURL(ctx, gh.c, url, &fetched)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	return fetched, links.page(), nil
+}
+
+// gitHubIssue is an issue or pull request as listed by the issues
+// API. Pull requests carry a "pull_request" member.
+type gitHubIssue struct {
+	Issue
+	PullRequest *struct {
+		URL string `json:"url"`
+	} `json:"pull_request"`
+}
+
+// ListIssues implements the IssueLister interface. Issues updated
+// before opts.Since are filtered on the server.
+func (gh *gitHubForge) ListIssues(ctx context.Context, repo string, opts ListOptions) ([]*Issue, Page, error) {
+	u := opts.Page
+	if len(u) == 0 {
+		u = fmt.Sprintf("%srepos/%s/issues?state=all&sort=updated&direction=desc&since=%s&per_page=%d",
+			gh.host, repo, url.QueryEscape(opts.Since.UTC().Format(time.RFC3339)), perPage)
+	}
+	fetched := []*gitHubIssue{}
+	links, err := fetchURL(ctx, gh.c, u, &fetched)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	issues := make([]*Issue, 0, len(fetched))
+	for _, item := range fetched {
+		if item.PullRequest == nil {
+			issues = append(issues, &item.Issue)
+		}
+	}
+	return issues, links.page(), nil
+}
+
+// maxSearchResults is the most results GitHub returns for a search.
+const maxSearchResults = 1000
+
+// searchItem is a pull request as returned by the search API, in the
+// form of an issue. Details are filled in by GetPullRequest.
+type searchItem struct {
+	PullRequest
+	Links struct {
+		URL      string `json:"url"`
+		MergedAt string `json:"merged_at"`
+	} `json:"pull_request"`
+}
+
+// SearchPullRequests implements the Searcher interface.
+func (gh *gitHubForge) SearchPullRequests(ctx context.Context, repo, query string, opts ListOptions) ([]*PullRequest, Page, error) {
+	u := opts.Page
+	if len(u) == 0 {
+		u = fmt.Sprintf("%ssearch/issues?q=%s&sort=updated&order=desc&per_page=%d", gh.host, url.QueryEscape(query), perPage)
+	}
+	var result struct {
+		TotalCount int           `json:"total_count"`
+		Items      []*searchItem `json:"items"`
+	}
+	links, err := fetchURL(ctx, gh.c, u, &result)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	page := links.page()
+	if page.Index == 1 && result.TotalCount > maxSearchResults {
+		log.Printf("%d pull requests match %q; only the most recently updated %d are returned\n",
+			result.TotalCount, query, maxSearchResults)
+	}
+	fetched := make([]*PullRequest, 0, len(result.Items))
+	for _, item := range result.Items {
+		pr := &item.PullRequest
+		pr.IssueURL = pr.URL
+		pr.URL = item.Links.URL
+		pr.MergedAt = item.Links.MergedAt
+		fetched = append(fetched, pr)
+	}
+	return fetched, page, nil
+}
+
+// estimateCost implements the costEstimator interface. Details of each
+// pull request require a request each for the pull request, its
+// commits, its reviews, its commit statuses, its check runs and its
+// files. Those of a pull request closed without merging skip the
+// reviews and CI checks, but require one for its events. Listings don't
+// report the number of commits or files, so further pages of long lists
+// aren't counted: the estimate is a lower bound.
+func (gh *gitHubForge) estimateCost(prs []*PullRequest) (string, int) {
+	requests := 0
+	for _, pr := range prs {
+		if pr.State == "closed" && !pr.IsMerged() {
+			requests += 4
+		} else {
+			requests += 6
+		}
+	}
+	return budgetKey(gh.host, "core"), requests
+}
+
+// GetPullRequest implements the Forge interface.
+func (gh *gitHubForge) GetPullRequest(ctx context.Context, pr *PullRequest) error {
+	_, err := fetchURL(ctx, gh.c, pr.URL, pr)
+	return err
+}
+
+// ListCommits implements the Forge interface. All pages are followed,
+// up to GitHub's limit of 250 commits.
+func (gh *gitHubForge) ListCommits(ctx context.Context, pr *PullRequest) ([]*Commit, error) {
+	commits := []*Commit{}
+	url := fmt.Sprintf("%s/commits?per_page=%d", pr.URL, perPage)
+	for len(url) > 0 && len(commits) < maxCommits {
+		page := []*Commit{}
+		links, err := fetchURL(ctx, gh.c, url, &page)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, page...)
+		url = links.Next
+	}
+	return commits, nil
+}
+
+// ListReviews implements the ReviewLister interface. All pages are
+// followed.
+func (gh *gitHubForge) ListReviews(ctx context.Context, pr *PullRequest) ([]*Review, error) {
+	reviews := []*Review{}
+	url := fmt.Sprintf("%s/reviews?per_page=%d", pr.URL, perPage)
+	for len(url) > 0 {
+		page := []*Review{}
+		links, err := fetchURL(ctx, gh.c, url, &page)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, page...)
+		url = links.Next
+	}
+	return reviews, nil
+}
+
+// FindCloser implements the CloserFinder interface, returning the
+// actor of the last "closed" event of the pull request's issue. All
+// pages of events are followed.
+func (gh *gitHubForge) FindCloser(ctx context.Context, pr *PullRequest) (User, error) {
+	issueURL := pr.IssueURL
+	if len(issueURL) == 0 {
+		_, name, err := gh.c.forgeFor(pr.Repo)
+		if err != nil {
+			return User{}, err
+		}
+		issueURL = fmt.Sprintf("%srepos/%s/issues/%d", gh.host, name, pr.Number)
+	}
+	var closer User
+	url := fmt.Sprintf("%s/events?per_page=%d", issueURL, perPage)
+	for len(url) > 0 {
+		page := []*struct {
+			Event string `json:"event"`
+			Actor User   `json:"actor"`
+		}{}
+		links, err := fetchURL(ctx, gh.c, url, &page)
+		if err != nil {
+			return User{}, err
+		}
+		for _, e := range page {
+			if e.Event == "closed" {
+				closer = e.Actor
+			}
+		}
+		url = links.Next
+	}
+	return closer, nil
+}
+
+// gitHubStatus is a commit status reported by a CI service.
+type gitHubStatus struct {
+	Context   string `json:"context"`
+	State     string `json:"state"` // "error", "failure", "pending" or "success"
+	TargetURL string `json:"target_url"`
+}
+
+// gitHubCheckRun is a check run reported by a GitHub App.
+type gitHubCheckRun struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`     // "queued", "in_progress" or "completed"
+	Conclusion string `json:"conclusion"` // Once completed, e.g. "success" or "failure"
+	HtmlURL    string `json:"html_url"`
+}
+
+// statusState summarizes the state of a commit status, which is
+// reported in lower case by the REST API and upper case by GraphQL.
+func statusState(state string) string {
+	switch strings.ToLower(state) {
+	case "success":
+		return checkSuccess
+	case "failure", "error":
+		return checkFailure
+	}
+	return checkPending
+}
+
+// checkRunState summarizes the state of a check run from its status
+// and conclusion, in either case. As on GitHub, a check run which was
+// cancelled or requires action counts as failed, while one which was
+// skipped or neutral counts as successful.
+func checkRunState(status, conclusion string) string {
+	if !strings.EqualFold(status, "completed") {
+		return checkPending
+	}
+	switch strings.ToLower(conclusion) {
+	case "success", "neutral", "skipped":
+		return checkSuccess
+	case "stale":
+		return checkPending
+	}
+	return checkFailure
+}
+
+// ListChecks implements the CheckLister interface, listing both the
+// commit statuses and the check runs of the head commit. Statuses are
+// listed newest first, so only the first status of each context is
+// kept. All pages are followed.
+func (gh *gitHubForge) ListChecks(ctx context.Context, pr *PullRequest) ([]*Check, error) {
+	_, name, err := gh.c.forgeFor(pr.Repo)
+	if err != nil {
+		return nil, err
+	}
+	checks := []*Check{}
+	statusesURL := pr.StatusesURL
+	if len(statusesURL) == 0 {
+		statusesURL = fmt.Sprintf("%srepos/%s/statuses/%s", gh.host, name, pr.Head.SHA)
+	}
+	seen := map[string]bool{}
+	u := fmt.Sprintf("%s?per_page=%d", statusesURL, perPage)
+	for len(u) > 0 {
+		page := []*gitHubStatus{}
+		links, err := fetchURL(ctx, gh.c, u, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range page {
+			if !seen[s.Context] {
+				seen[s.Context] = true
+				checks = append(checks, &Check{Name: s.Context, State: statusState(s.State), URL: s.TargetURL})
+			}
+		}
+		u = links.Next
+	}
+	u = fmt.Sprintf("%srepos/%s/commits/%s/check-runs?per_page=%d", gh.host, name, pr.Head.SHA, perPage)
+	for len(u) > 0 {
+		var page struct {
+			CheckRuns []*gitHubCheckRun `json:"check_runs"`
+		}
+		links, err := fetchURL(ctx, gh.c, u, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, cr := range page.CheckRuns {
+			checks = append(checks, &Check{Name: cr.Name, State: checkRunState(cr.Status, cr.Conclusion), URL: cr.HtmlURL})
+		}
+		u = links.Next
+	}
+	return checks, nil
+}
+
+// ListFiles implements the Forge interface. All pages are followed, up
+// to GitHub's limit of 3000 files.
+func (gh *gitHubForge) ListFiles(ctx context.Context, pr *PullRequest) ([]*File, error) {
+	files := []*File{}
+	url := fmt.Sprintf("%s/files?per_page=%d", pr.URL, perPage)
+	for len(url) > 0 && len(files) < maxFiles {
+		page := []*File{}
+		links, err := fetchURL(ctx, gh.c, url, &page)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page...)
+		url = links.Next
+	}
+	return files, nil
+}
@@ -0,0 +1,399 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitLabUser is a GitLab user as embedded in merge requests.
+type gitLabUser struct {
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatar_url"`
+	WebURL    string `json:"web_url"`
+}
+
+func (u *gitLabUser) toUser() User {
+	if u == nil {
+		return User{}
+	}
+	return User{Login: u.Username, Name: u.Name, AvatarURL: u.AvatarURL, HtmlURL: u.WebURL}
+}
+
+// gitLabMilestone is a GitLab milestone as embedded in merge requests
+// and issues.
+type gitLabMilestone struct {
+	IID     int    `json:"iid"`
+	Title   string `json:"title"`
+	State   string `json:"state"` // "active" or "closed"
+	DueDate string `json:"due_date"`
+	WebURL  string `json:"web_url"`
+}
+
+func (m *gitLabMilestone) toMilestone() *Milestone {
+	if m == nil {
+		return nil
+	}
+	state := "open"
+	if m.State == "closed" {
+		state = "closed"
+	}
+	return &Milestone{Number: m.IID, Title: m.Title, State: state, DueOn: m.DueDate, HtmlURL: m.WebURL}
+}
+
+// gitLabLabels maps label names onto labels. GitLab lists only the
+// names of labels unless asked for details.
+func gitLabLabels(names []string) []*Label {
+	var labels []*Label
+	for _, name := range names {
+		labels = append(labels, &Label{Name: name})
+	}
+	return labels
+}
+
+// gitLabMergeRequest is a GitLab merge request, as listed or fetched.
+type gitLabMergeRequest struct {
+	ID             int         `json:"id"`
+	IID            int         `json:"iid"`
+	Title          string      `json:"title"`
+	Description    string      `json:"description"`
+	State          string      `json:"state"` // "opened", "closed", "locked" or "merged"
+	CreatedAt      string      `json:"created_at"`
+	UpdatedAt      string      `json:"updated_at"`
+	MergedAt       string      `json:"merged_at"`
+	ClosedAt       string      `json:"closed_at"`
+	Author         *gitLabUser `json:"author"`
+	MergeUser      *gitLabUser `json:"merge_user"`
+	ClosedBy       *gitLabUser `json:"closed_by"`
+	WebURL         string      `json:"web_url"`
+	MergeCommitSHA string      `json:"merge_commit_sha"`
+	SHA            string      `json:"sha"` // Head commit of the source branch
+	SourceBranch   string      `json:"source_branch"`
+	UserNotesCount int         `json:"user_notes_count"`
+	ChangesCount   string      `json:"changes_count"` // Only when fetched; e.g. "3" or "1000+"
+
+	Labels    []string         `json:"labels"`
+	Milestone *gitLabMilestone `json:"milestone"`
+}
+
+// gitLabIssue is a GitLab issue, as listed.
+type gitLabIssue struct {
+	ID             int           `json:"id"`
+	IID            int           `json:"iid"`
+	Title          string        `json:"title"`
+	Description    string        `json:"description"`
+	State          string        `json:"state"` // "opened" or "closed"
+	CreatedAt      string        `json:"created_at"`
+	UpdatedAt      string        `json:"updated_at"`
+	ClosedAt       string        `json:"closed_at"`
+	Author         *gitLabUser   `json:"author"`
+	Assignees      []*gitLabUser `json:"assignees"`
+	WebURL         string        `json:"web_url"`
+	UserNotesCount int           `json:"user_notes_count"`
+
+	Labels    []string         `json:"labels"`
+	Milestone *gitLabMilestone `json:"milestone"`
+}
+
+// gitLabCommit is a commit of a merge request.
+type gitLabCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	WebURL  string `json:"web_url"`
+}
+
+// gitLabDiff is the diff of a file changed by a merge request.
+type gitLabDiff struct {
+	OldPath     string `json:"old_path"`
+	NewPath     string `json:"new_path"`
+	NewFile     bool   `json:"new_file"`
+	RenamedFile bool   `json:"renamed_file"`
+	DeletedFile bool   `json:"deleted_file"`
+	Diff        string `json:"diff"`
+}
+
+// gitLabForge implements Forge using the GitLab REST API (v4), mapping
+// merge requests onto pull requests. Projects are specified by their
+// full path (:group[/:subgroup...]/:project) and page tokens are the
+// URLs parsed from the "Link" response header.
+type gitLabForge struct {
+	c     *Config
+	host  string // API root, e.g. https://gitlab.com/api/v4/
+	token string // Personal, project or group access token; may be empty
+}
+
+func newGitLabForge(c *Config, host, token string) *gitLabForge {
+	if !strings.HasSuffix(host, "/") {
+		host += "/"
+	}
+	return &gitLabForge{c: c, host: host, token: token}
+}
+
+// fetch fetches the specified URL, authorized with the GitLab token.
+func (gl *gitLabForge) fetch(ctx context.Context, url string, value interface{}) (links, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return links{}, err
+	}
+	if len(gl.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+gl.token)
+	}
+	return fetchRequest(ctx, gl.c, req, value)
+}
+
+// projectURL returns the API URL of the project.
+func (gl *gitLabForge) projectURL(project string) string {
+	return gl.host + "projects/" + url.PathEscape(project)
+}
+
+// ListPullRequests implements the Forge interface. Merge requests are
+// filtered by opts.Since on the server.
+func (gl *gitLabForge) ListPullRequests(ctx context.Context, project string, opts ListOptions) ([]*PullRequest, Page, error) {
+	u := opts.Page
+	if len(u) == 0 {
+		order := "updated"
+		if opts.Sort == "created" {
+			order = "created"
+		}
+		u = fmt.Sprintf("%s/merge_requests?state=all&order_by=%s_at&sort=desc&%s_after=%s&per_page=%d",
+			gl.projectURL(project), order, order, url.QueryEscape(opts.Since.UTC().Format(time.RFC3339)), perPage)
+	}
+	mrs := []*gitLabMergeRequest{}
+	links, err := gl.fetch(ctx, u, &mrs)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	prs := make([]*PullRequest, 0, len(mrs))
+	for _, mr := range mrs {
+		pr := &PullRequest{}
+		mr.fill(pr, fmt.Sprintf("%s/merge_requests/%d", gl.projectURL(project), mr.IID))
+		prs = append(prs, pr)
+	}
+	return prs, links.page(), nil
+}
+
+// ListIssues implements the IssueLister interface. Issues updated
+// before opts.Since are filtered on the server.
+func (gl *gitLabForge) ListIssues(ctx context.Context, project string, opts ListOptions) ([]*Issue, Page, error) {
+	u := opts.Page
+	if len(u) == 0 {
+		u = fmt.Sprintf("%s/issues?order_by=updated_at&sort=desc&updated_after=%s&per_page=%d",
+			gl.projectURL(project), url.QueryEscape(opts.Since.UTC().Format(time.RFC3339)), perPage)
+	}
+	fetched := []*gitLabIssue{}
+	links, err := gl.fetch(ctx, u, &fetched)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	issues := make([]*Issue, 0, len(fetched))
+	for _, gi := range fetched {
+		is := &Issue{
+			URL:       fmt.Sprintf("%s/issues/%d", gl.projectURL(project), gi.IID),
+			ID:        gi.ID,
+			HtmlURL:   gi.WebURL,
+			Number:    gi.IID,
+			State:     "open",
+			Title:     gi.Title,
+			User:      gi.Author.toUser(),
+			Body:      gi.Description,
+			Labels:    gitLabLabels(gi.Labels),
+			Milestone: gi.Milestone.toMilestone(),
+			Comments:  gi.UserNotesCount,
+			CreatedAt: gi.CreatedAt,
+			UpdatedAt: gi.UpdatedAt,
+			ClosedAt:  gi.ClosedAt,
+		}
+		if gi.State == "closed" {
+			is.State = "closed"
+		}
+		for _, a := range gi.Assignees {
+			is.Assignees = append(is.Assignees, a.toUser())
+		}
+		issues = append(issues, is)
+	}
+	return issues, links.page(), nil
+}
+
+// fill maps the merge request onto pr, whose API URL is apiURL.
+func (mr *gitLabMergeRequest) fill(pr *PullRequest, apiURL string) {
+	pr.URL = apiURL
+	pr.ID = mr.ID
+	pr.Number = mr.IID
+	pr.HtmlURL = mr.WebURL
+	pr.DiffURL = mr.WebURL + ".diff"
+	pr.PatchURL = mr.WebURL + ".patch"
+	pr.Title = mr.Title
+	pr.Body = mr.Description
+	pr.User = mr.Author.toUser()
+	pr.CreatedAt = mr.CreatedAt
+	pr.UpdatedAt = mr.UpdatedAt
+	pr.MergedAt = mr.MergedAt
+	pr.ClosedAt = mr.ClosedAt
+	pr.MergeCommitSHA = mr.MergeCommitSHA
+	pr.Head.SHA, pr.Head.Ref = mr.SHA, mr.SourceBranch
+	pr.Labels = gitLabLabels(mr.Labels)
+	pr.Milestone = mr.Milestone.toMilestone()
+	pr.Comments = mr.UserNotesCount
+	pr.State = "open"
+	switch mr.State {
+	case "merged":
+		// Merged merge requests have no closed_at time.
+		pr.State = "closed"
+		pr.Merged = true
+		pr.MergedBy = mr.MergeUser.toUser()
+		pr.ClosedAt = mr.MergedAt
+	case "closed":
+		pr.State = "closed"
+		pr.ClosedBy = mr.ClosedBy.toUser()
+	}
+	if len(mr.ChangesCount) > 0 {
+		// Counts above GitLab's limit are reported as e.g. "1000+".
+		pr.ChangedFiles, _ = strconv.Atoi(strings.TrimSuffix(mr.ChangesCount, "+"))
+	}
+}
+
+// GetPullRequest implements the Forge interface.
+func (gl *gitLabForge) GetPullRequest(ctx context.Context, pr *PullRequest) error {
+	mr := &gitLabMergeRequest{}
+	if _, err := gl.fetch(ctx, pr.URL, mr); err != nil {
+		return err
+	}
+	mr.fill(pr, pr.URL)
+	return nil
+}
+
+// ListCommits implements the Forge interface. All pages are followed.
+func (gl *gitLabForge) ListCommits(ctx context.Context, pr *PullRequest) ([]*Commit, error) {
+	commits := []*Commit{}
+	u := fmt.Sprintf("%s/commits?per_page=%d", pr.URL, perPage)
+	for len(u) > 0 {
+		page := []*gitLabCommit{}
+		links, err := gl.fetch(ctx, u, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, glc := range page {
+			c := &Commit{SHA: glc.ID}
+			c.Commit.Message = glc.Message
+			c.Commit.URL = glc.WebURL
+			commits = append(commits, c)
+		}
+		u = links.Next
+	}
+	pr.Commits = len(commits)
+	return commits, nil
+}
+
+// ListReviews implements the ReviewLister interface. GitLab records
+// approvals rather than reviews, so each current approval of the merge
+// request is listed as an approving review.
+func (gl *gitLabForge) ListReviews(ctx context.Context, pr *PullRequest) ([]*Review, error) {
+	var approvals struct {
+		ApprovedBy []struct {
+			User *gitLabUser `json:"user"`
+		} `json:"approved_by"`
+	}
+	if _, err := gl.fetch(ctx, pr.URL+"/approvals", &approvals); err != nil {
+		return nil, err
+	}
+	reviews := []*Review{}
+	for _, a := range approvals.ApprovedBy {
+		reviews = append(reviews, &Review{User: a.User.toUser(), State: reviewApproved})
+	}
+	return reviews, nil
+}
+
+// ListChecks implements the CheckLister interface, returning the
+// latest pipeline of the merge request as its only check.
+func (gl *gitLabForge) ListChecks(ctx context.Context, pr *PullRequest) ([]*Check, error) {
+	pipelines := []struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+		WebURL string `json:"web_url"`
+	}{}
+	if _, err := gl.fetch(ctx, fmt.Sprintf("%s/pipelines?per_page=1", pr.URL), &pipelines); err != nil {
+		return nil, err
+	}
+	checks := []*Check{}
+	if len(pipelines) > 0 {
+		p := pipelines[0]
+		ch := &Check{Name: fmt.Sprintf("pipeline #%d", p.ID), State: checkPending, URL: p.WebURL}
+		switch p.Status {
+		case "success", "skipped":
+			ch.State = checkSuccess
+		case "failed", "canceled":
+			ch.State = checkFailure
+		}
+		checks = append(checks, ch)
+	}
+	return checks, nil
+}
+
+// ListFiles implements the Forge interface, listing the diffs of the
+// merge request (requires GitLab 15.7 or later). GitLab doesn't report
+// line counts for merge requests, so they are counted from the diffs
+// and totaled in pr.Additions and pr.Deletions.
+func (gl *gitLabForge) ListFiles(ctx context.Context, pr *PullRequest) ([]*File, error) {
+	files := []*File{}
+	pr.Additions, pr.Deletions = 0, 0
+	u := fmt.Sprintf("%s/diffs?per_page=%d", pr.URL, perPage)
+	for len(u) > 0 && len(files) < maxFiles {
+		page := []*gitLabDiff{}
+		links, err := gl.fetch(ctx, u, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range page {
+			f := &File{Filename: d.NewPath, Status: "modified", Patch: d.Diff}
+			switch {
+			case d.NewFile:
+				f.Status = "added"
+			case d.DeletedFile:
+				f.Status = "removed"
+			case d.RenamedFile:
+				f.Status = "renamed"
+			}
+			f.Additions, f.Deletions = diffStats(d.Diff)
+			f.Changes = f.Additions + f.Deletions
+			pr.Additions += f.Additions
+			pr.Deletions += f.Deletions
+			files = append(files, f)
+		}
+		u = links.Next
+	}
+	return files, nil
+}
+
+// diffStats returns the number of lines added and deleted by a unified
+// diff without file headers, as returned by GitLab.
+func diffStats(diff string) (additions, deletions int) {
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+") {
+			additions++
+		} else if strings.HasPrefix(line, "-") {
+			deletions++
+		}
+	}
+	return additions, deletions
+}
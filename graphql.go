@@ -0,0 +1,852 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// graphQLPageSize is the number of pull requests fetched per query.
+// Each carries its first page of commits and files, so the page is kept
+// smaller than the REST default to bound the cost of a query.
+const graphQLPageSize = 25
+
+// GraphQL fragments; a query must include exactly the fragments it
+// uses.
+const (
+	gqlPullRequestFragment = `
+fragment prFields on PullRequest {
+  databaseId number url title body state locked
+  createdAt updatedAt closedAt mergedAt merged
+  author { ...userFields }
+  mergedBy { ...userFields }
+  mergeCommit { oid }
+  headRefName headRefOid
+  headCommit: commits(last: 1) { nodes { commit { statusCheckRollup { ...checkFields } } } }
+  additions deletions changedFiles
+  comments { totalCount }
+  labels(first: 100) { nodes { name color description } }
+  milestone { number title state dueOn url }
+  closedEvent: timelineItems(last: 1, itemTypes: [CLOSED_EVENT]) { nodes { ... on ClosedEvent { actor { ...userFields } } } }
+  commits(first: 100) { ...commitFields }
+  files(first: 100) { ...fileFields }
+  reviews(first: 100) { ...reviewFields }
+}
+fragment userFields on Actor { login avatarUrl url }
+fragment checkFields on StatusCheckRollup {
+  contexts(first: 100) {
+    nodes {
+      ... on CheckRun { name status conclusion detailsUrl }
+      ... on StatusContext { context state targetUrl }
+    }
+  }
+}
+`
+	gqlCommitsFragment = `
+fragment commitFields on PullRequestCommitConnection {
+  totalCount
+  pageInfo { hasNextPage endCursor }
+  nodes { commit { oid message url } }
+}
+`
+	gqlFilesFragment = `
+fragment fileFields on PullRequestChangedFileConnection {
+  totalCount
+  pageInfo { hasNextPage endCursor }
+  nodes { path additions deletions changeType }
+}
+`
+	gqlReviewsFragment = `
+fragment reviewFields on PullRequestReviewConnection {
+  pageInfo { hasNextPage endCursor }
+  nodes { databaseId state submittedAt url author { login avatarUrl url } }
+}
+`
+)
+
+const gqlListPullRequests = `
+query($owner: String!, $name: String!, $order: PullRequestOrderField!, $first: Int!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    pullRequests(first: $first, after: $after, orderBy: {field: $order, direction: DESC}) {
+      totalCount
+      pageInfo { hasNextPage endCursor }
+      nodes { ...prFields }
+    }
+  }
+}
+` + gqlPullRequestFragment + gqlCommitsFragment + gqlFilesFragment + gqlReviewsFragment
+
+// gqlCountPullRequests lists only the fields of pull requests needed
+// to count them.
+const gqlCountPullRequests = `
+query($owner: String!, $name: String!, $order: PullRequestOrderField!, $first: Int!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    pullRequests(first: $first, after: $after, orderBy: {field: $order, direction: DESC}) {
+      totalCount
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        databaseId number url state
+        createdAt updatedAt closedAt mergedAt merged
+        labels(first: 100) { nodes { name color description } }
+      }
+    }
+  }
+}
+`
+
+const gqlSearchPullRequests = `
+query($query: String!, $first: Int!, $after: String) {
+  search(query: $query, type: ISSUE, first: $first, after: $after) {
+    issueCount
+    pageInfo { hasNextPage endCursor }
+    nodes { ... on PullRequest { ...prFields } }
+  }
+}
+` + gqlPullRequestFragment + gqlCommitsFragment + gqlFilesFragment + gqlReviewsFragment
+
+const gqlListCommits = `
+query($owner: String!, $name: String!, $number: Int!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      commits(first: 100, after: $after) { ...commitFields }
+    }
+  }
+}
+` + gqlCommitsFragment
+
+const gqlListFiles = `
+query($owner: String!, $name: String!, $number: Int!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      files(first: 100, after: $after) { ...fileFields }
+    }
+  }
+}
+` + gqlFilesFragment
+
+const gqlListReviews = `
+query($owner: String!, $name: String!, $number: Int!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      reviews(first: 100, after: $after) { ...reviewFields }
+    }
+  }
+}
+` + gqlReviewsFragment
+
+const gqlListIssues = `
+query($owner: String!, $name: String!, $since: DateTime!, $first: Int!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    issues(first: $first, after: $after, orderBy: {field: UPDATED_AT, direction: DESC}, filterBy: {since: $since}) {
+      totalCount
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        databaseId number url title body state
+        createdAt updatedAt closedAt
+        author { ...userFields }
+        comments { totalCount }
+        labels(first: 100) { nodes { name color description } }
+        assignees(first: 25) { nodes { ...userFields } }
+        milestone { number title state dueOn url }
+      }
+    }
+  }
+}
+fragment userFields on Actor { login avatarUrl url }
+`
+
+type gqlPageInfo struct {
+	HasNextPage bool   `json:"hasNextPage"`
+	EndCursor   string `json:"endCursor"`
+}
+
+type gqlUser struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatarUrl"`
+	URL       string `json:"url"`
+}
+
+type gqlCommits struct {
+	TotalCount int         `json:"totalCount"`
+	PageInfo   gqlPageInfo `json:"pageInfo"`
+	Nodes      []struct {
+		Commit struct {
+			OID     string `json:"oid"`
+			Message string `json:"message"`
+			URL     string `json:"url"`
+		} `json:"commit"`
+	} `json:"nodes"`
+}
+
+type gqlFiles struct {
+	TotalCount int         `json:"totalCount"`
+	PageInfo   gqlPageInfo `json:"pageInfo"`
+	Nodes      []struct {
+		Path       string `json:"path"`
+		Additions  int    `json:"additions"`
+		Deletions  int    `json:"deletions"`
+		ChangeType string `json:"changeType"`
+	} `json:"nodes"`
+}
+
+type gqlReviews struct {
+	PageInfo gqlPageInfo `json:"pageInfo"`
+	Nodes    []struct {
+		DatabaseID  int      `json:"databaseId"`
+		State       string   `json:"state"`
+		SubmittedAt string   `json:"submittedAt"`
+		URL         string   `json:"url"`
+		Author      *gqlUser `json:"author"`
+	} `json:"nodes"`
+}
+
+// gqlMilestone is the milestone of a pull request or issue.
+type gqlMilestone struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	DueOn  string `json:"dueOn"`
+	URL    string `json:"url"`
+}
+
+func (m *gqlMilestone) toMilestone() *Milestone {
+	if m == nil {
+		return nil
+	}
+	return &Milestone{
+		Number:  m.Number,
+		Title:   m.Title,
+		State:   strings.ToLower(m.State),
+		DueOn:   m.DueOn,
+		HtmlURL: m.URL,
+	}
+}
+
+// gqlIssue is an issue as listed by gqlListIssues.
+type gqlIssue struct {
+	DatabaseID int      `json:"databaseId"`
+	Number     int      `json:"number"`
+	URL        string   `json:"url"`
+	Title      string   `json:"title"`
+	Body       string   `json:"body"`
+	State      string   `json:"state"`
+	CreatedAt  string   `json:"createdAt"`
+	UpdatedAt  string   `json:"updatedAt"`
+	ClosedAt   string   `json:"closedAt"`
+	Author     *gqlUser `json:"author"`
+	Comments   struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"comments"`
+	Labels struct {
+		Nodes []*Label `json:"nodes"`
+	} `json:"labels"`
+	Assignees struct {
+		Nodes []*gqlUser `json:"nodes"`
+	} `json:"assignees"`
+	Milestone *gqlMilestone `json:"milestone"`
+}
+
+// gqlCheckContext is either a check run or a commit status; the fields
+// of the other are empty.
+type gqlCheckContext struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	DetailsURL string `json:"detailsUrl"`
+	Context    string `json:"context"`
+	State      string `json:"state"`
+	TargetURL  string `json:"targetUrl"`
+}
+
+// toCheck maps the check run or commit status onto a Check.
+func (cc *gqlCheckContext) toCheck() *Check {
+	if len(cc.Context) > 0 {
+		return &Check{Name: cc.Context, State: statusState(cc.State), URL: cc.TargetURL}
+	}
+	return &Check{Name: cc.Name, State: checkRunState(cc.Status, cc.Conclusion), URL: cc.DetailsURL}
+}
+
+type gqlPullRequest struct {
+	DatabaseID  int      `json:"databaseId"`
+	Number      int      `json:"number"`
+	URL         string   `json:"url"`
+	Title       string   `json:"title"`
+	Body        string   `json:"body"`
+	State       string   `json:"state"`
+	Locked      bool     `json:"locked"`
+	CreatedAt   string   `json:"createdAt"`
+	UpdatedAt   string   `json:"updatedAt"`
+	ClosedAt    string   `json:"closedAt"`
+	MergedAt    string   `json:"mergedAt"`
+	Merged      bool     `json:"merged"`
+	Author      *gqlUser `json:"author"`
+	MergedBy    *gqlUser `json:"mergedBy"`
+	MergeCommit *struct {
+		OID string `json:"oid"`
+	} `json:"mergeCommit"`
+	HeadRefName string `json:"headRefName"`
+	HeadRefOID  string `json:"headRefOid"`
+	HeadCommit  struct {
+		Nodes []struct {
+			Commit struct {
+				StatusCheckRollup *struct {
+					Contexts struct {
+						Nodes []*gqlCheckContext `json:"nodes"`
+					} `json:"contexts"`
+				} `json:"statusCheckRollup"`
+			} `json:"commit"`
+		} `json:"nodes"`
+	} `json:"headCommit"`
+	Additions    int `json:"additions"`
+	Deletions    int `json:"deletions"`
+	ChangedFiles int `json:"changedFiles"`
+	Comments     struct {
+		TotalCount int `json:"totalCount"`
+	} `json:"comments"`
+	Labels struct {
+		Nodes []*Label `json:"nodes"`
+	} `json:"labels"`
+	Commits gqlCommits `json:"commits"`
+	Files   gqlFiles   `json:"files"`
+	Reviews gqlReviews `json:"reviews"`
+
+	Milestone   *gqlMilestone `json:"milestone"`
+	ClosedEvent struct {
+		Nodes []struct {
+			Actor *gqlUser `json:"actor"`
+		} `json:"nodes"`
+	} `json:"closedEvent"`
+}
+
+// gqlPrefetched holds the first pages of commits, files and reviews,
+// and the CI checks of the head commit, returned with a pull request
+// listing, for ListCommits, ListFiles, ListReviews and ListChecks until
+// released.
+type gqlPrefetched struct {
+	owner, name string
+	commits     gqlCommits
+	files       gqlFiles
+	reviews     gqlReviews
+	checks      []*gqlCheckContext
+}
+
+// graphQLForge implements Forge using the GitHub GraphQL API (v4).
+// Pull requests are listed together with their commits, files and
+// reviews, so a digest requires a few paginated queries instead of one
+// REST request to list plus four per pull request.
+type graphQLForge struct {
+	c        *Config
+	host     string // REST API root, e.g. https://api.github.com/
+	endpoint string
+
+	mu         sync.Mutex
+	prefetched map[*PullRequest]*gqlPrefetched
+}
+
+func newGraphQLForge(c *Config, host string) *graphQLForge {
+	// The GraphQL endpoint of an enterprise instance is a sibling of its
+	// REST API root (e.g. /api/graphql rather than /api/v3/).
+	endpoint := host + "graphql"
+	if strings.HasSuffix(host, "/v3/") {
+		endpoint = strings.TrimSuffix(host, "v3/") + "graphql"
+	}
+	return &graphQLForge{
+		c:          c,
+		host:       host,
+		endpoint:   endpoint,
+		prefetched: map[*PullRequest]*gqlPrefetched{},
+	}
+}
+
+// query posts the GraphQL query with the specified variables and
+// parses the "data" member of the response into value.
+func (gf *graphQLForge) query(ctx context.Context, query string, vars map[string]interface{}, value interface{}) error {
+	body, err := json.Marshal(struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}{query, vars})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", gf.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := fetchRequest(ctx, gf.c, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		return errors.New(fmt.Sprintf("GraphQL query failed: %s", resp.Errors[0].Message))
+	}
+	if len(resp.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Data, value)
+}
+
+// splitRepo splits a repository specified as :owner/:repo.
+func splitRepo(repo string) (owner, name string, err error) {
+	parts := strings.Split(repo, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", errors.New(fmt.Sprintf("repository %q not formatted as :owner/:repo", repo))
+	}
+	return parts[0], parts[1], nil
+}
+
+// ListPullRequests implements the Forge interface. Page tokens have the
+// form <index>:<cursor>. Listings for counting omit commits, files,
+// reviews and checks.
+func (gf *graphQLForge) ListPullRequests(ctx context.Context, repo string, opts ListOptions) ([]*PullRequest, Page, error) {
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	order := "UPDATED_AT"
+	if opts.Sort == "created" {
+		order = "CREATED_AT"
+	}
+	vars := map[string]interface{}{
+		"owner": owner,
+		"name":  name,
+		"order": order,
+		"first": graphQLPageSize,
+	}
+	index, err := pageVars(opts.Page, vars)
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	var data struct {
+		Repository *struct {
+			PullRequests struct {
+				TotalCount int               `json:"totalCount"`
+				PageInfo   gqlPageInfo       `json:"pageInfo"`
+				Nodes      []*gqlPullRequest `json:"nodes"`
+			} `json:"pullRequests"`
+		} `json:"repository"`
+	}
+	query := gqlListPullRequests
+	if opts.Count {
+		query = gqlCountPullRequests
+	}
+	if err := gf.query(ctx, query, vars, &data); err != nil {
+		return nil, Page{}, err
+	}
+	if data.Repository == nil {
+		return nil, Page{}, nil
+	}
+
+	conn := data.Repository.PullRequests
+	page := newPage(index, conn.TotalCount, conn.PageInfo)
+	if opts.Count {
+		prs := make([]*PullRequest, 0, len(conn.Nodes))
+		for _, node := range conn.Nodes {
+			prs = append(prs, node.toPullRequest(gf.host, repo))
+		}
+		return prs, page, nil
+	}
+	return gf.prefetch(repo, conn.Nodes), page, nil
+}
+
+// ListIssues implements the IssueLister interface. Issues updated
+// before opts.Since are filtered on the server. Page tokens have the
+// same form as for ListPullRequests.
+func (gf *graphQLForge) ListIssues(ctx context.Context, repo string, opts ListOptions) ([]*Issue, Page, error) {
+	owner, name, err := splitRepo(repo)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	vars := map[string]interface{}{
+		"owner": owner,
+		"name":  name,
+		"since": opts.Since.UTC().Format(time.RFC3339),
+		"first": graphQLPageSize,
+	}
+	index, err := pageVars(opts.Page, vars)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	var data struct {
+		Repository *struct {
+			Issues struct {
+				TotalCount int         `json:"totalCount"`
+				PageInfo   gqlPageInfo `json:"pageInfo"`
+				Nodes      []*gqlIssue `json:"nodes"`
+			} `json:"issues"`
+		} `json:"repository"`
+	}
+	if err := gf.query(ctx, gqlListIssues, vars, &data); err != nil {
+		return nil, Page{}, err
+	}
+	if data.Repository == nil {
+		return nil, Page{}, nil
+	}
+	conn := data.Repository.Issues
+	issues := make([]*Issue, 0, len(conn.Nodes))
+	for _, node := range conn.Nodes {
+		is := &Issue{
+			URL:       fmt.Sprintf("%srepos/%s/issues/%d", gf.host, repo, node.Number),
+			ID:        node.DatabaseID,
+			HtmlURL:   node.URL,
+			Number:    node.Number,
+			State:     strings.ToLower(node.State),
+			Title:     node.Title,
+			Body:      node.Body,
+			Labels:    node.Labels.Nodes,
+			Milestone: node.Milestone.toMilestone(),
+			Comments:  node.Comments.TotalCount,
+			CreatedAt: node.CreatedAt,
+			UpdatedAt: node.UpdatedAt,
+			ClosedAt:  node.ClosedAt,
+		}
+		if node.Author != nil {
+			is.User = node.Author.toUser()
+		}
+		for _, a := range node.Assignees.Nodes {
+			is.Assignees = append(is.Assignees, a.toUser())
+		}
+		issues = append(issues, is)
+	}
+	return issues, newPage(index, conn.TotalCount, conn.PageInfo), nil
+}
+
+// SearchPullRequests implements the Searcher interface. Page tokens
+// have the same form as for ListPullRequests.
+func (gf *graphQLForge) SearchPullRequests(ctx context.Context, repo, query string, opts ListOptions) ([]*PullRequest, Page, error) {
+	vars := map[string]interface{}{
+		"query": query + " sort:updated-desc",
+		"first": graphQLPageSize,
+	}
+	index, err := pageVars(opts.Page, vars)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	var data struct {
+		Search struct {
+			IssueCount int               `json:"issueCount"`
+			PageInfo   gqlPageInfo       `json:"pageInfo"`
+			Nodes      []*gqlPullRequest `json:"nodes"`
+		} `json:"search"`
+	}
+	if err := gf.query(ctx, gqlSearchPullRequests, vars, &data); err != nil {
+		return nil, Page{}, err
+	}
+	conn := data.Search
+	return gf.prefetch(repo, conn.Nodes), newPage(index, conn.IssueCount, conn.PageInfo), nil
+}
+
+// pageVars sets the "after" variable from the page token, if any, and
+// returns the index of the page.
+func pageVars(token string, vars map[string]interface{}) (int, error) {
+	i := strings.IndexByte(token, ':')
+	if i <= 0 {
+		return 1, nil
+	}
+	index, err := strconv.Atoi(token[:i])
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid page token %q", token))
+	}
+	vars["after"] = token[i+1:]
+	return index, nil
+}
+
+// newPage returns the position of the page at index within a listing
+// of total pull requests.
+func newPage(index, total int, pageInfo gqlPageInfo) Page {
+	page := Page{
+		Index: index,
+		Count: (total + graphQLPageSize - 1) / graphQLPageSize,
+	}
+	if pageInfo.HasNextPage {
+		page.Next = fmt.Sprintf("%d:%s", index+1, pageInfo.EndCursor)
+	}
+	return page
+}
+
+// prefetch maps the listed pull requests of repo, retaining their
+// first pages of commits, files and reviews and the CI checks of the
+// head commit.
+func (gf *graphQLForge) prefetch(repo string, nodes []*gqlPullRequest) []*PullRequest {
+	owner, name, _ := splitRepo(repo)
+	prs := make([]*PullRequest, 0, len(nodes))
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+	for _, node := range nodes {
+		pr := node.toPullRequest(gf.host, repo)
+		p := &gqlPrefetched{
+			owner:   owner,
+			name:    name,
+			commits: node.Commits,
+			files:   node.Files,
+			reviews: node.Reviews,
+		}
+		for _, n := range node.HeadCommit.Nodes {
+			if rollup := n.Commit.StatusCheckRollup; rollup != nil {
+				p.checks = rollup.Contexts.Nodes
+			}
+		}
+		gf.prefetched[pr] = p
+		prs = append(prs, pr)
+	}
+	return prs
+}
+
+// toPullRequest maps the GraphQL pull request onto the REST
+// representation used by the templates.
+func (node *gqlPullRequest) toPullRequest(host, repo string) *PullRequest {
+	pr := &PullRequest{
+		URL:          fmt.Sprintf("%srepos/%s/pulls/%d", host, repo, node.Number),
+		ID:           node.DatabaseID,
+		HtmlURL:      node.URL,
+		DiffURL:      node.URL + ".diff",
+		PatchURL:     node.URL + ".patch",
+		Number:       node.Number,
+		State:        "open",
+		Locked:       node.Locked,
+		Title:        node.Title,
+		Body:         node.Body,
+		CreatedAt:    node.CreatedAt,
+		UpdatedAt:    node.UpdatedAt,
+		ClosedAt:     node.ClosedAt,
+		MergedAt:     node.MergedAt,
+		Merged:       node.Merged,
+		Comments:     node.Comments.TotalCount,
+		Commits:      node.Commits.TotalCount,
+		Additions:    node.Additions,
+		Deletions:    node.Deletions,
+		ChangedFiles: node.ChangedFiles,
+	}
+	if node.State != "OPEN" {
+		pr.State = "closed"
+	}
+	if node.Author != nil {
+		pr.User = node.Author.toUser()
+	}
+	if node.MergedBy != nil {
+		pr.MergedBy = node.MergedBy.toUser()
+	}
+	if node.State == "CLOSED" {
+		for _, n := range node.ClosedEvent.Nodes {
+			if n.Actor != nil {
+				pr.ClosedBy = n.Actor.toUser()
+			}
+		}
+	}
+	if node.MergeCommit != nil {
+		pr.MergeCommitSHA = node.MergeCommit.OID
+	}
+	pr.Head.SHA, pr.Head.Ref = node.HeadRefOID, node.HeadRefName
+	pr.Labels = node.Labels.Nodes
+	pr.Milestone = node.Milestone.toMilestone()
+	return pr
+}
+
+func (u *gqlUser) toUser() User {
+	return User{Login: u.Login, AvatarURL: u.AvatarURL, HtmlURL: u.URL}
+}
+
+// GetPullRequest implements the Forge interface. Pull requests are
+// listed with full detail, so there is nothing further to fetch.
+func (gf *graphQLForge) GetPullRequest(ctx context.Context, pr *PullRequest) error {
+	return nil
+}
+
+// claim returns the data prefetched for pr. Pull requests not returned
+// by ListPullRequests have none.
+func (gf *graphQLForge) claim(pr *PullRequest) (*gqlPrefetched, error) {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+	p, ok := gf.prefetched[pr]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("pull request %s was not listed via GraphQL", pr.HtmlURL))
+	}
+	return p, nil
+}
+
+// Release implements the Releaser interface.
+func (gf *graphQLForge) Release(pr *PullRequest) {
+	gf.mu.Lock()
+	defer gf.mu.Unlock()
+	delete(gf.prefetched, pr)
+}
+
+// ListCommits implements the Forge interface. Commits beyond those
+// listed with the pull request are fetched, up to GitHub's limit of
+// 250 commits.
+func (gf *graphQLForge) ListCommits(ctx context.Context, pr *PullRequest) ([]*Commit, error) {
+	p, err := gf.claim(pr)
+	if err != nil {
+		return nil, err
+	}
+	commits := []*Commit{}
+	for conn := p.commits; ; {
+		for _, node := range conn.Nodes {
+			c := &Commit{SHA: node.Commit.OID}
+			c.Commit.Message = node.Commit.Message
+			c.Commit.URL = node.Commit.URL
+			commits = append(commits, c)
+		}
+		if !conn.PageInfo.HasNextPage || len(commits) >= maxCommits {
+			return commits, nil
+		}
+		var data struct {
+			Repository struct {
+				PullRequest struct {
+					Commits gqlCommits `json:"commits"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+		if err := gf.query(ctx, gqlListCommits, p.vars(pr, conn.PageInfo), &data); err != nil {
+			return nil, err
+		}
+		conn = data.Repository.PullRequest.Commits
+	}
+}
+
+// ListFiles implements the Forge interface. Files beyond those listed
+// with the pull request are fetched, up to GitHub's limit of 3000
+// files.
+func (gf *graphQLForge) ListFiles(ctx context.Context, pr *PullRequest) ([]*File, error) {
+	p, err := gf.claim(pr)
+	if err != nil {
+		return nil, err
+	}
+	files := []*File{}
+	for conn := p.files; ; {
+		for _, node := range conn.Nodes {
+			files = append(files, &File{
+				Filename:  node.Path,
+				Status:    fileStatus(node.ChangeType),
+				Additions: node.Additions,
+				Deletions: node.Deletions,
+				Changes:   node.Additions + node.Deletions,
+			})
+		}
+		if !conn.PageInfo.HasNextPage || len(files) >= maxFiles {
+			return files, nil
+		}
+		var data struct {
+			Repository struct {
+				PullRequest struct {
+					Files gqlFiles `json:"files"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+		if err := gf.query(ctx, gqlListFiles, p.vars(pr, conn.PageInfo), &data); err != nil {
+			return nil, err
+		}
+		conn = data.Repository.PullRequest.Files
+	}
+}
+
+// fileStatus maps the GraphQL change type of a file onto the status
+// reported by the REST API.
+func fileStatus(changeType string) string {
+	switch changeType {
+	case "ADDED":
+		return "added"
+	case "DELETED":
+		return "removed"
+	case "RENAMED":
+		return "renamed"
+	case "COPIED":
+		return "copied"
+	case "CHANGED":
+		return "changed"
+	}
+	return "modified"
+}
+
+// ListReviews implements the ReviewLister interface. Reviews beyond
+// those listed with the pull request are fetched.
+func (gf *graphQLForge) ListReviews(ctx context.Context, pr *PullRequest) ([]*Review, error) {
+	p, err := gf.claim(pr)
+	if err != nil {
+		return nil, err
+	}
+	reviews := []*Review{}
+	for conn := p.reviews; ; {
+		for _, node := range conn.Nodes {
+			r := &Review{
+				ID:          node.DatabaseID,
+				State:       node.State,
+				SubmittedAt: node.SubmittedAt,
+				HtmlURL:     node.URL,
+			}
+			if node.Author != nil {
+				r.User = node.Author.toUser()
+			}
+			reviews = append(reviews, r)
+		}
+		if !conn.PageInfo.HasNextPage {
+			return reviews, nil
+		}
+		var data struct {
+			Repository struct {
+				PullRequest struct {
+					Reviews gqlReviews `json:"reviews"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+		if err := gf.query(ctx, gqlListReviews, p.vars(pr, conn.PageInfo), &data); err != nil {
+			return nil, err
+		}
+		conn = data.Repository.PullRequest.Reviews
+	}
+}
+
+// ListChecks implements the CheckLister interface, returning the CI
+// checks listed with the pull request. Only the first 100 checks are
+// listed.
+func (gf *graphQLForge) ListChecks(ctx context.Context, pr *PullRequest) ([]*Check, error) {
+	p, err := gf.claim(pr)
+	if err != nil {
+		return nil, err
+	}
+	checks := []*Check{}
+	for _, cc := range p.checks {
+		checks = append(checks, cc.toCheck())
+	}
+	return checks, nil
+}
+
+// vars returns the variables for a query of the page of a pull
+// request's connection which follows pageInfo.
+func (p *gqlPrefetched) vars(pr *PullRequest, pageInfo gqlPageInfo) map[string]interface{} {
+	return map[string]interface{}{
+		"owner":  p.owner,
+		"name":   p.name,
+		"number": pr.Number,
+		"after":  pageInfo.EndCursor,
+	}
+}
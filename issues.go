@@ -0,0 +1,169 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// Issue holds an issue of a repository. Forges which track pull
+// requests as issues (such as GitHub) list both together; pull
+// requests are excluded from the issues of a digest.
+type Issue struct {
+	URL       string     `json:"url"`
+	ID        int        `json:"id"`
+	HtmlURL   string     `json:"html_url"`
+	Number    int        `json:"number"`
+	State     string     `json:"state"` // "open" or "closed"
+	Title     string     `json:"title"`
+	User      User       `json:"user"`
+	Body      string     `json:"body"`
+	Labels    []*Label   `json:"labels"`
+	Assignees []User     `json:"assignees"`
+	Milestone *Milestone `json:"milestone"`
+	Comments  int        `json:"comments"`
+	CreatedAt string     `json:"created_at"`
+	UpdatedAt string     `json:"updated_at"`
+	ClosedAt  string     `json:"closed_at"`
+
+	Repo string `json:"-"` // Repository, as named in --repos
+}
+
+func (is *Issue) CommentsStr() string {
+	return format(is.Comments)
+}
+
+// CreatedAtStr returns created at timestap in human-readable format
+// according to server-local time.
+func (is *Issue) CreatedAtStr() string {
+	t, err := time.Parse(time.RFC3339, is.CreatedAt)
+	if err != nil {
+		return is.CreatedAt
+	}
+	return t.Local().Format("Mon Jan _2 15:04:05")
+}
+
+// ClosedAtStr returns closed at timestap in human-readable format
+// according to server-local time.
+func (is *Issue) ClosedAtStr() string {
+	t, err := time.Parse(time.RFC3339, is.ClosedAt)
+	if err != nil {
+		return is.ClosedAt
+	}
+	return t.Local().Format("Mon Jan _2 15:04:05")
+}
+
+type Issues []*Issue
+
+func (slice Issues) Len() int {
+	return len(slice)
+}
+
+func (slice Issues) Less(i, j int) bool {
+	return slice[i].Comments > slice[j].Comments
+}
+
+func (slice Issues) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// QueryIssues queries the issues of each repository opened or closed
+// since c.FetchSince. Returns a slice each for opened and closed
+// issues. Repositories on forges without issues are skipped.
+func QueryIssues(ctx context.Context, c *Config) (opened, closed []*Issue, err error) {
+	defer c.stats.phase("issues")()
+	for _, repo := range c.Repos {
+		os, cs, err := QueryRepoIssues(ctx, c, repo)
+		if err != nil {
+			return nil, nil, err
+		}
+		opened = append(opened, os...)
+		closed = append(closed, cs...)
+	}
+	return opened, closed, nil
+}
+
+// QueryRepoIssues queries the issues of the repo opened or closed
+// since c.FetchSince, listing issues by descending update time. A
+// repository with issues disabled has none.
+func QueryRepoIssues(ctx context.Context, c *Config, repo string) ([]*Issue, []*Issue, error) {
+	forge, name, err := c.forgeFor(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	il, ok := forge.(IssueLister)
+	if !ok {
+		return nil, nil, nil
+	}
+	log.Printf("querying issues from %s opened or closed after %s\n", repo, c.FetchSince.Format(time.RFC3339))
+	ctx = withRepo(ctx, name)
+	opts := ListOptions{Sort: "updated", Since: c.FetchSince}
+	opened, closed := []*Issue{}, []*Issue{}
+	for first, done := true, false; (first || len(opts.Page) > 0) && !done; first = false {
+		fetched, page, err := il.ListIssues(ctx, name, opts)
+		var nf *notFoundError
+		if errors.As(err, &nf) {
+			// GitHub answers 410 (Gone) for disabled issues.
+			log.Printf("no issues in %s: %s\n", repo, err)
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Page = page.Next
+		for _, is := range fetched {
+			// Break out of loop if updated timestamp is <= FetchSince.
+			t, err := time.Parse(time.RFC3339, is.UpdatedAt)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !c.FetchSince.Before(t) {
+				done = true
+				break
+			}
+			is.Repo = repo
+			if !labelsMatch(c, is.Labels) {
+				continue
+			}
+			var date string
+			switch is.State {
+			case "open":
+				date = is.CreatedAt
+			case "closed":
+				date = is.ClosedAt
+			default:
+				continue
+			}
+			if t, err = time.Parse(time.RFC3339, date); err != nil {
+				return nil, nil, err
+			}
+			if !c.FetchSince.Before(t) {
+				continue
+			}
+			if is.State == "open" {
+				opened = append(opened, is)
+			} else {
+				closed = append(closed, is)
+			}
+		}
+	}
+	log.Printf("found %s opened and %s closed issues in %s\n", format(len(opened)), format(len(closed)), repo)
+	return opened, closed, nil
+}
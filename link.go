@@ -0,0 +1,137 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// links holds the pagination targets of a "Link" HTTP header. Each is
+// empty if the relation is not present. Total is the total number of
+// items in the listing if reported by an "X-Total-Count" header, as
+// by Gitea, or else -1.
+type links struct {
+	Next  string
+	Prev  string
+	First string
+	Last  string
+	Total int
+}
+
+// parseLinkHeader parses the value of a "Link" HTTP header as
+// specified by RFC 8288, e.g.:
+//
+//	<https://api.github.com/...&page=3>; rel="next", <https://api.github.com/...&page=9>; rel="last"
+//
+// Link values may appear in any order, and a single link may carry
+// several space-separated relation types. Malformed link values are
+// skipped.
+func parseLinkHeader(header string) links {
+	var l links
+	s := header
+	for {
+		start := strings.IndexByte(s, '<')
+		if start < 0 {
+			return l
+		}
+		end := strings.IndexByte(s[start:], '>')
+		if end < 0 {
+			return l
+		}
+		target := s[start+1 : start+end]
+		s = s[start+end+1:]
+
+		// Parameters extend to the next comma which isn't quoted.
+		var params string
+		params, s = splitLinkParams(s)
+		for _, param := range strings.Split(params, ";") {
+			eq := strings.IndexByte(param, '=')
+			if eq < 0 || !strings.EqualFold(strings.TrimSpace(param[:eq]), "rel") {
+				continue
+			}
+			rels := strings.Trim(strings.TrimSpace(param[eq+1:]), `"`)
+			for _, rel := range strings.Fields(rels) {
+				switch strings.ToLower(rel) {
+				case "next":
+					l.Next = target
+				case "prev", "previous":
+					l.Prev = target
+				case "first":
+					l.First = target
+				case "last":
+					l.Last = target
+				}
+			}
+		}
+	}
+}
+
+// splitLinkParams splits s at the first comma outside of a quoted
+// string, returning the parameters of the current link value and the
+// remainder of the header.
+func splitLinkParams(s string) (params, rest string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+// pageNumber returns the value of the "page" query parameter of the
+// link target, or 0 if absent or unparseable.
+func pageNumber(target string) int {
+	if len(target) == 0 {
+		return 0
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.Atoi(u.Query().Get("page"))
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// page returns the position within the paginated listing of the page
+// whose response carried these links.
+func (l links) page() Page {
+	p := Page{Next: l.Next, Index: 1}
+	if n := pageNumber(l.Next); n > 0 {
+		p.Index = n - 1
+	} else if n := pageNumber(l.Prev); n > 0 {
+		p.Index = n + 1
+	}
+	if n := pageNumber(l.Last); n > 0 {
+		p.Count = n
+	} else if len(l.Next) == 0 {
+		p.Count = p.Index
+	}
+	return p
+}
@@ -0,0 +1,80 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import "testing"
+
+func TestParseLinkHeader(t *testing.T) {
+	const base = "https://api.github.com/repos/o/r/pulls?state=all"
+	testCases := []struct {
+		header string
+		links  links
+		page   Page
+	}{
+		{
+			header: "",
+			links:  links{},
+			page:   Page{Index: 1, Count: 1},
+		},
+		// First page.
+		{
+			header: `<` + base + `&page=2>; rel="next", <` + base + `&page=9>; rel="last"`,
+			links:  links{Next: base + "&page=2", Last: base + "&page=9"},
+			page:   Page{Next: base + "&page=2", Index: 1, Count: 9},
+		},
+		// Last page, with only prev and first links.
+		{
+			header: `<` + base + `&page=8>; rel="prev", <` + base + `&page=1>; rel="first"`,
+			links:  links{Prev: base + "&page=8", First: base + "&page=1"},
+			page:   Page{Index: 9, Count: 9},
+		},
+		// Values in any order.
+		{
+			header: `<` + base + `&page=9>; rel="last", <` + base + `&page=1>; rel="first", ` +
+				`<` + base + `&page=5>; rel="next", <` + base + `&page=3>; rel="prev"`,
+			links: links{Next: base + "&page=5", Prev: base + "&page=3", First: base + "&page=1", Last: base + "&page=9"},
+			page:  Page{Next: base + "&page=5", Index: 4, Count: 9},
+		},
+		// Several relation types for one link, and unquoted relations.
+		{
+			header: `<` + base + `&page=1>; rel="prev first", <` + base + `&page=3>; rel=next`,
+			links:  links{Next: base + "&page=3", Prev: base + "&page=1", First: base + "&page=1"},
+			page:   Page{Next: base + "&page=3", Index: 2},
+		},
+		// Commas within quoted parameters don't separate link values.
+		{
+			header: `<` + base + `&page=2>; title="a, b"; rel="next", <` + base + `&page=4>; title="c,d"; REL="last"`,
+			links:  links{Next: base + "&page=2", Last: base + "&page=4"},
+			page:   Page{Next: base + "&page=2", Index: 1, Count: 4},
+		},
+		// Malformed values are skipped.
+		{
+			header: `<` + base + `&page=2; rel="next", garbage`,
+			links:  links{},
+			page:   Page{Index: 1, Count: 1},
+		},
+	}
+	for i, tc := range testCases {
+		l := parseLinkHeader(tc.header)
+		if l != tc.links {
+			t.Errorf("%d: expected links %+v; got %+v", i, tc.links, l)
+		}
+		if p := l.page(); p != tc.page {
+			t.Errorf("%d: expected page %+v; got %+v", i, tc.page, p)
+		}
+	}
+}
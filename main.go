@@ -17,10 +17,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"reflect"
 	"strings"
 	"time"
@@ -28,6 +35,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
+	"github.com/spf13/pflag"
 )
 
 // pflagValue wraps flag.Value and implements the extra methods of the
@@ -62,11 +70,33 @@ const hostDesc = "GitHub API hostname, including scheme"
 
 const accessTokenDesc = "GitHub access token for authorized rate limits"
 
+const tokenFileDesc = "File containing GitHub access tokens, one per line; several tokens form a pool used in rotation"
+
+const tokenCommandDesc = "Shell command, such as a credential helper, which outputs GitHub access tokens, one per line"
+
+const tokenEnvDesc = "Environment variable containing comma-separated GitHub access tokens"
+
+const hostTokenEnvDesc = "Environment variables containing the access tokens of GitHub instances other than --host, by hostname (e.g. ghe.example.com=GHE_TOKEN); by default taken from ~/.netrc"
+
 const fetchBeforeDesc = "Fetch all opened and closed pull requests up until this date"
 
 const fetchSinceDesc = "Fetch all opened and closed pull requests since this date"
 
-const reposDesc = "GitHub repositories, formatted as comma-separated list :owner/:repo[,:owner/:repo,...]"
+const reposDesc = "Repositories, formatted as comma-separated list :owner/:repo[,:owner/:repo,...]; prefix repositories on GitHub instances other than --host with their hostname, e.g. ghe.example.com/team/svc, GitLab projects with \"gitlab:\", e.g. gitlab:group/project, Gitea repositories with \"gitea:\", Gerrit projects with \"gerrit:\" and the paths of local clones with \"git:\""
+
+const giteaHostDesc = "Gitea or Forgejo API root, including scheme, for repositories prefixed with \"gitea:\" (e.g. https://gitea.example.com/api/v1/)"
+
+const giteaTokenDesc = "Gitea or Forgejo access token; by default taken from the GITEA_TOKEN environment variable"
+
+const gerritHostDesc = "Gerrit root URL, including scheme, for projects prefixed with \"gerrit:\" (e.g. https://review.example.com/)"
+
+const gerritUserDesc = "Gerrit username; by default, Gerrit is accessed anonymously"
+
+const gerritTokenDesc = "Gerrit HTTP password of --gerrit-user; by default taken from the GERRIT_TOKEN environment variable"
+
+const gitLabHostDesc = "GitLab API root, including scheme, for repositories prefixed with \"gitlab:\""
+
+const gitLabTokenDesc = "GitLab access token; by default taken from the GITLAB_TOKEN environment variable"
 
 const templateDesc = "Go HTML template filename (see templates/ for examples)"
 
@@ -74,17 +104,69 @@ const outDirDesc = "Output directory"
 
 const inlineStylesDesc = "Inline styles in generated html; good for standalone files"
 
+const recordDesc = "Record all GitHub API responses to this directory"
+
+const replayDesc = "Replay GitHub API responses from this directory instead of using the network"
+
+const apiDesc = "GitHub API used to fetch pull requests: \"rest\" (v3) or \"graphql\" (v4, fewer requests)"
+
+const timeoutDesc = "Abort the run if it takes longer than this duration (0 for no limit)"
+
+const requestTimeoutDesc = "Abort and retry any single GitHub API request taking longer than this duration"
+
+const appIDDesc = "GitHub App ID (or client ID) with which to authenticate instead of --token"
+
+const appKeyDesc = "Filename of the GitHub App's PEM encoded private key"
+
+const appInstallationDesc = "GitHub App installation ID; by default, the installation is looked up for each repository"
+
+const checkBudgetDesc = "Refuse to fetch pull request details if the estimated number of requests exceeds the remaining rate limit"
+
+const caCertDesc = "PEM file of additional CA certificates trusted for the --host API host only"
+
+const clientCertDesc = "PEM file of a client certificate presented to the --host API host for mutual TLS"
+
+const clientKeyDesc = "PEM file of the private key of the --client-cert certificate"
+
+const proxyDesc = "HTTP(S) proxy URL; by default taken from the HTTPS_PROXY environment variable"
+
+const insecureSkipVerifyDesc = "Don't verify the TLS certificate of the --host API host, but only that host (for staging instances only)"
+
+const configFileDesc = "JSON file of flag values keyed by flag name, e.g. {\"host\": \"https://github.example.com/api/v3/\", \"ca-cert\": \"ca.pem\"}; flags given on the command line take precedence"
+
+const concurrencyDesc = "Maximum number of pull requests for which to fetch details concurrently"
+
+const selectDesc = "Selection of candidate pull requests: \"list\" all pull requests updated since --since, or \"search\" for them, applying the --search-* qualifiers on the server"
+
+const searchLabelDesc = "With --select=search, only pull requests having all of these labels"
+
+const issuesDesc = "Include issues opened or closed since --since in the digest, on forges which track issues"
+
+const includeLabelDesc = "Only pull requests having at least one of these labels"
+
+const excludeLabelDesc = "Omit pull requests having any of these labels (e.g. no-digest)"
+
+const searchAuthorDesc = "With --select=search, only pull requests opened by one of these users"
+
+const searchBaseDesc = "With --select=search, only pull requests into this base branch"
+
+const statsFileDesc = "Write statistics on the run, such as requests made per endpoint, to this file as JSON"
+
+const onErrorDesc = "Handling of pull requests whose details can't be fetched: \"fail\" the run, \"skip\" the pull request or \"mark\" it as incomplete"
+
+const cacheDesc = "Cache GitHub API responses in this directory, revalidating them with conditional requests (except with --record)"
+
 var digestCmd = &cobra.Command{
 	Use:   "repo-digest",
 	Short: "generate daily digests of repository activity",
 	Long: `
 Generate an HTML digest of repository activity (default stylesheet
-included). The digest includes two sections: a list of all newly-open
-pull requests as well as a list of all recently-committed pull
-requests.
+included). The digest includes sections listing all newly-opened pull
+requests, all recently-merged pull requests and all pull requests
+recently closed without merging.
 
 Fetches GitHub data for the specified repository and computes the digest
-since the --since date. The digest contains two sections including:
+since the --since date.
 
 Each pull request includes basic information, including title, author,
 date, and metrics about which subdirectories of the repository are
@@ -93,7 +175,17 @@ most affected.
 Pull requests are ordered by total modification size (additions +
 deletions).
 
-An access token can be specified via --token. By default, uses an empty
+Issues opened or closed since the --since date, excluding pull
+requests, are included in sections of their own with --issues.
+
+An access token is taken from the first of the following which supplies
+one: --token, --token-file, --token-command (e.g. "gh auth token"), the
+environment variable named by --token-env (GITHUB_TOKEN by default), and
+the entry for the API host in ~/.netrc. Avoid --token, which leaks the
+token into shell history. Several tokens (one per line in a file or in
+command output, or comma-separated in the environment variable) form a
+pool; when one token exceeds its rate limit, requests rotate to the next
+instead of waiting for the limit to reset. By default, uses an empty
 token, which is limited to only 50 GitHub requests per hour, rate limited
 based on IP address.
 
@@ -108,31 +200,152 @@ address can be specified with the --host flag.  Note that while the core github
 API root is specified via subdomain (https://api.github.com/), private enterprise
 instances generally have an API root specified by URL path
 (https://github.example.com/api/v3/, for example).
+
+Repositories on GitHub instances other than --host are included by
+prefixing them with the hostname of the instance (e.g.
+ghe.example.com/team/svc, whose API root is
+https://ghe.example.com/api/v3/). Each instance's access token is taken
+from the environment variable named for its hostname by --host-token-env
+(e.g. ghe.example.com=GHE_TOKEN), or else from its entry in ~/.netrc.
+
+Merge requests of GitLab projects are included by prefixing the project
+path with "gitlab:" in --repos (e.g. gitlab:group/subgroup/project). The
+GitLab API root is specified with --gitlab-host and its access token
+with --gitlab-token or the GITLAB_TOKEN environment variable. Likewise,
+pull requests of Gitea and Forgejo repositories are included with the
+prefix "gitea:", using --gitea-host and --gitea-token (or GITEA_TOKEN).
+Changes of Gerrit projects are included with the prefix "gerrit:", using
+--gerrit-host, and --gerrit-user with --gerrit-token (or GERRIT_TOKEN)
+if authentication is required.
+
+Without any API access, a digest may be made from a local clone by
+prefixing its path with "git:" (e.g. git:/src/cockroach). Each commit on
+the first-parent history of its HEAD is listed as a merged pull request,
+with the changes it merged.
 `,
-	Example: `  repo-digest --repos=cockroachdb/cockroach --token=f87456b1112dadb2d831a5792bf2ca9a6afca7bc`,
+	Example: `  repo-digest --repos=cockroachdb/cockroach --token-file=$HOME/.github-token`,
 	RunE:    runDigest,
 }
 
 // Config holds config information used to query GitHub.
 type Config struct {
-	Host         string    // Github API Hostname (https://api.github.com)
-	Repos        []string  // Repositories (:owner/:repo)
-	Token        string    // Access token
-	Before       string    // RFC 3339 date
-	Since        string    // RFC 3339 date
-	Template     string    // HTML template filename
-	OutDir       string    // Output directory
-	InlineStyles bool      // Inline style into generated html
-	Now          time.Time // Current time for this run of the repo-digest
-	FetchSince   time.Time // Fetch all opened and closed PRs since this time
-	acceptHeader string    // Optional Accept: header value
+	Host               string            // Github API Hostname (https://api.github.com)
+	Repos              []string          // Repositories (:owner/:repo, optionally prefixed by forge)
+	GiteaHost          string            // Gitea API root (https://gitea.example.com/api/v1/)
+	GiteaToken         string            // Gitea access token
+	GerritHost         string            // Gerrit root URL (https://review.example.com/)
+	GerritUser         string            // Gerrit username
+	GerritToken        string            // Gerrit HTTP password
+	GitLabHost         string            // GitLab API root (https://gitlab.com/api/v4/)
+	GitLabToken        string            // GitLab access token
+	Token              string            // Access token
+	TokenFile          string            // File containing access tokens
+	TokenCommand       string            // Command which outputs access tokens
+	TokenEnv           string            // Environment variable containing access tokens
+	HostTokenEnv       map[string]string // Environment variables containing access tokens by GitHub hostname
+	AppID              string            // GitHub App ID, to authenticate as an app installation
+	AppKeyFile         string            // GitHub App private key filename
+	AppInstall         int64             // GitHub App installation ID; 0 to look up per repository
+	Before             string            // RFC 3339 date
+	Since              string            // RFC 3339 date
+	Template           string            // HTML template filename
+	OutDir             string            // Output directory
+	InlineStyles       bool              // Inline style into generated html
+	Now                time.Time         // Current time for this run of the repo-digest
+	FetchSince         time.Time         // Fetch all opened and closed PRs since this time
+	Record             string            // Directory to which responses are recorded
+	Replay             string            // Directory from which responses are replayed
+	CacheDir           string            // Directory in which responses are cached
+	API                string            // GitHub API to use ("rest" or "graphql")
+	Concurrency        int               // Maximum concurrent pull request detail queries
+	CheckBudget        bool              // Refuse runs exceeding the remaining rate limit budget
+	CACert             string            // Additional CA certificates (PEM file)
+	ClientCert         string            // Client certificate for mutual TLS (PEM file)
+	ClientKey          string            // Client certificate private key (PEM file)
+	Proxy              string            // HTTP(S) proxy URL
+	InsecureSkipVerify bool              // Skip verification of the server certificate
+	ConfigFile         string            // JSON file of flag values
+	Timeout            time.Duration     // Limit on the duration of the run; 0 for none
+	RequestTimeout     time.Duration     // Limit on the duration of each request
+	Select             string            // Selection of candidate pull requests ("list" or "search")
+	SearchLabels       []string          // Search qualifier: labels, all required
+	SearchAuthors      []string          // Search qualifier: authors, any of which matches
+	SearchBase         string            // Search qualifier: base branch
+	IncludeLabels      []string          // Labels, any of which a pull request or issue must have
+	ExcludeLabels      []string          // Labels, none of which a pull request or issue may have
+	Issues             bool              // Include issues in the digest
+	OnError            string            // Policy for failed detail queries ("fail", "skip" or "mark")
+	StatsFile          string            // File to which run statistics are written
+	Failures           []*PullRequest    // Pull requests whose details could not be fetched
+	Forge              Forge             // Source of pull request data for GitHub repositories
+	forges             map[string]Forge  // Sources of pull request data by repository prefix
+	hostForges         map[string]Forge  // Sources of pull request data by GitHub hostname
+	hostTokens         map[string]string // Access tokens by GitHub API hostname
+	client             *http.Client      // HTTP client used for all requests
+	tokens             tokenSource       // Access tokens; nil to use Token alone
+	cache              *responseCache    // Optional on-disk response cache
+	limiter            rateLimiter       // Rate limit budgets shared by all requests
+	stats              runStats          // Request and timing statistics for the run
+	acceptHeader       string            // Optional Accept: header value
 }
 
 var cfg = Config{
 	Template: "templates/default",
 }
 
-func initConfig() error {
+// loadConfigFile sets the flags specified in the JSON config file,
+// except for those already set on the command line. Values are
+// formatted as they would be on the command line; lists may be given
+// as JSON arrays.
+func loadConfigFile(flags *pflag.FlagSet, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	// Decode numbers as written so that large IDs aren't reformatted.
+	var values map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return err
+	}
+	for name, v := range values {
+		f := flags.Lookup(name)
+		if f == nil {
+			return errors.Errorf("unknown flag %q", name)
+		}
+		if f.Changed {
+			continue
+		}
+		var value string
+		if list, ok := v.([]interface{}); ok {
+			strs := make([]string, len(list))
+			for i, elem := range list {
+				strs[i] = fmt.Sprint(elem)
+			}
+			value = strings.Join(strs, ",")
+		} else if m, ok := v.(map[string]interface{}); ok {
+			strs := make([]string, 0, len(m))
+			for k, elem := range m {
+				strs = append(strs, fmt.Sprintf("%s=%s", k, elem))
+			}
+			value = strings.Join(strs, ",")
+		} else {
+			value = fmt.Sprint(v)
+		}
+		if err := f.Value.Set(value); err != nil {
+			return errors.Errorf("invalid value for %q: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func initConfig(flags *pflag.FlagSet) error {
+	if len(cfg.ConfigFile) > 0 {
+		if err := loadConfigFile(flags, cfg.ConfigFile); err != nil {
+			return errors.Errorf("failed to load config file %q: %s", cfg.ConfigFile, err)
+		}
+	}
 	if len(cfg.Repos) == 0 {
 		return errors.Errorf("repositories not specified; use --repos=:owner/:repo[,:owner/:repo,...]")
 	}
@@ -151,35 +364,190 @@ func initConfig() error {
 	}
 	cfg.FetchSince = cfg.FetchSince.Local()
 
+	switch cfg.OnError {
+	case onErrorFail, onErrorSkip, onErrorMark:
+	default:
+		return errors.Errorf("unknown --on-error=%s; use \"fail\", \"skip\" or \"mark\"", cfg.OnError)
+	}
+	if len(cfg.Record) > 0 && len(cfg.Replay) > 0 {
+		return errors.Errorf("--record and --replay are mutually exclusive")
+	}
+	switch cfg.Select {
+	case selectList:
+		if len(cfg.SearchLabels)+len(cfg.SearchAuthors)+len(cfg.SearchBase) > 0 {
+			return errors.Errorf("--search-label, --search-author and --search-base require --select=search")
+		}
+	case selectSearch:
+	default:
+		return errors.Errorf("unknown --select=%s; use \"list\" or \"search\"", cfg.Select)
+	}
+	if cfg.client, err = newHTTPClient(&cfg); err != nil {
+		return errors.Errorf("failed to create HTTP client: %s", err)
+	}
+	if len(cfg.AppID) > 0 {
+		if len(cfg.Token)+len(cfg.TokenFile)+len(cfg.TokenCommand) > 0 {
+			return errors.Errorf("--app-id is mutually exclusive with --token, --token-file and --token-command")
+		}
+		if len(cfg.AppKeyFile) == 0 {
+			return errors.Errorf("GitHub App private key not specified; use --app-key=:pem_file")
+		}
+		if cfg.tokens, err = newAppTokenSource(&cfg, cfg.AppID, cfg.AppKeyFile, cfg.AppInstall); err != nil {
+			return errors.Errorf("failed to load GitHub App private key: %s", err)
+		}
+	} else {
+		tokens, err := resolveTokens(&cfg)
+		if err != nil {
+			return errors.Errorf("failed to resolve access token: %s", err)
+		}
+		if len(tokens) == 1 {
+			cfg.Token = tokens[0]
+		} else if len(tokens) > 1 {
+			log.Printf("rotating through a pool of %d access tokens\n", len(tokens))
+			cfg.tokens = newTokenPool(tokens)
+		}
+	}
+	if len(cfg.CacheDir) > 0 {
+		if cfg.cache, err = newResponseCache(cfg.CacheDir); err != nil {
+			return errors.Errorf("failed to open cache %q: %s", cfg.CacheDir, err)
+		}
+	}
+	if cfg.Forge, err = newGitHubAPIForge(&cfg, cfg.Host); err != nil {
+		return err
+	}
+	if len(cfg.GitLabToken) == 0 {
+		cfg.GitLabToken = os.Getenv("GITLAB_TOKEN")
+	}
+	if len(cfg.GiteaToken) == 0 {
+		cfg.GiteaToken = os.Getenv("GITEA_TOKEN")
+	}
+	if len(cfg.GerritToken) == 0 {
+		cfg.GerritToken = os.Getenv("GERRIT_TOKEN")
+	}
+	cfg.forges = map[string]Forge{
+		"gitlab": newGitLabForge(&cfg, cfg.GitLabHost, cfg.GitLabToken),
+		"gitea":  newGiteaForge(&cfg, cfg.GiteaHost, cfg.GiteaToken),
+		"gerrit": newGerritForge(&cfg, cfg.GerritHost, cfg.GerritUser, cfg.GerritToken),
+		"git":    newGitForge(&cfg),
+	}
+	// Repositories qualified by hostname share a forge per GitHub
+	// instance, authorized with that instance's own token.
+	cfg.hostForges = map[string]Forge{}
+	cfg.hostTokens = map[string]string{}
+	for _, repo := range cfg.Repos {
+		hostname, _, ok := splitHost(repo)
+		if !ok || cfg.hostForges[hostname] != nil {
+			continue
+		}
+		root := gitHubAPIRoot(hostname)
+		if u, err := url.Parse(cfg.Host); root == cfg.Host || (err == nil && u.Host == hostname) {
+			cfg.hostForges[hostname] = cfg.Forge
+			continue
+		}
+		if cfg.hostForges[hostname], err = newGitHubAPIForge(&cfg, root); err != nil {
+			return err
+		}
+		token, err := resolveHostToken(&cfg, hostname, root)
+		if err != nil {
+			return errors.Errorf("failed to resolve access token for %s: %s", hostname, err)
+		}
+		if len(token) > 0 {
+			u, _ := url.Parse(root)
+			cfg.hostTokens[u.Host] = token
+		}
+	}
+	for _, repo := range cfg.Repos {
+		if _, _, err := cfg.forgeFor(repo); err != nil {
+			return err
+		}
+		if strings.HasPrefix(repo, "gitea:") && len(cfg.GiteaHost) == 0 {
+			return errors.Errorf("Gitea API root not specified for %s; use --gitea-host=:api_root", repo)
+		}
+		if strings.HasPrefix(repo, "gerrit:") && len(cfg.GerritHost) == 0 {
+			return errors.Errorf("Gerrit root URL not specified for %s; use --gerrit-host=:url", repo)
+		}
+	}
 	return nil
 }
 
+// runContext returns the context for a run of a command, which is
+// canceled on interrupt (Ctrl-C) or after cfg.Timeout, if specified.
+func runContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if cfg.Timeout == 0 {
+		return ctx, stop
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	return ctx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// reportStats logs the statistics of the run, also writing them to
+// cfg.StatsFile if specified.
+func reportStats() {
+	r := cfg.stats.report(&cfg.limiter)
+	r.log()
+	if len(cfg.StatsFile) > 0 {
+		if err := r.write(cfg.StatsFile); err != nil {
+			log.Printf("failed to write statistics to %s: %s\n", cfg.StatsFile, err)
+		}
+	}
+}
+
 func runDigest(c *cobra.Command, args []string) error {
-	if err := initConfig(); err != nil {
+	if err := initConfig(c.Flags()); err != nil {
 		return err
 	}
+	defer reportStats()
+	ctx, cancel := runContext()
+	defer cancel()
 
 	log.Printf("fetching GitHub data for repositories %s\n", cfg.Repos)
-	open, closed, err := Query(&cfg)
+	open, merged, abandoned, err := Query(ctx, &cfg)
 	if err != nil {
 		return errors.Errorf("failed to query data: %s", err)
 	}
+	var openedIssues, closedIssues []*Issue
+	if cfg.Issues {
+		if openedIssues, closedIssues, err = QueryIssues(ctx, &cfg); err != nil {
+			return errors.Errorf("failed to query issues: %s", err)
+		}
+	}
 	log.Printf("creating digest for repositories %s\n", cfg.Repos)
-	if err := Digest(&cfg, open, closed); err != nil {
+	endRender := cfg.stats.phase("render")
+	if err := Digest(&cfg, open, merged, abandoned, openedIssues, closedIssues); err != nil {
 		return errors.Errorf("failed to create digest: %s", err)
 	}
+	endRender()
+	if len(cfg.Failures) > 0 {
+		log.Printf("unable to fetch details for %d pull requests:\n", len(cfg.Failures))
+		for _, pr := range cfg.Failures {
+			log.Printf("  %s: %s\n", pr.HtmlURL, pr.FetchError)
+		}
+	}
 	var latestTime time.Time
 	for _, pr := range open {
 		if t := mustParseTime3339(pr.CreatedAt); t.After(latestTime) {
 			latestTime = t
 		}
 	}
-	for _, pr := range closed {
+	for _, pr := range append(append([]*PullRequest(nil), merged...), abandoned...) {
 		if t := mustParseTime3339(pr.ClosedAt); t.After(latestTime) {
 			latestTime = t
 		}
 	}
-	if len(open)+len(closed) == 0 {
+	for _, is := range openedIssues {
+		if t := mustParseTime3339(is.CreatedAt); t.After(latestTime) {
+			latestTime = t
+		}
+	}
+	for _, is := range closedIssues {
+		if t := mustParseTime3339(is.ClosedAt); t.After(latestTime) {
+			latestTime = t
+		}
+	}
+	if len(open)+len(merged)+len(abandoned)+len(openedIssues)+len(closedIssues) == 0 {
 		latestTime = time.Now()
 	}
 	latestTime = latestTime.Local()
@@ -200,13 +568,17 @@ Output monthly counts of pull requests
 }
 
 func runCountMonthly(c *cobra.Command, args []string) error {
-	if err := initConfig(); err != nil {
+	if err := initConfig(c.Flags()); err != nil {
 		return err
 	}
+	defer reportStats()
+
+	ctx, cancel := runContext()
+	defer cancel()
 
 	log.Printf("counting monthly pull requests for repositories %s", cfg.Repos)
 
-	counts, err := CountMonthly(&cfg)
+	counts, err := CountMonthly(ctx, &cfg)
 	if err != nil {
 		return err
 	}
@@ -253,12 +625,49 @@ func init() {
 	// Add persistent flags to the top-level command.
 	digestCmd.PersistentFlags().StringVar(&cfg.Host, "host", "https://api.github.com/", hostDesc)
 	digestCmd.PersistentFlags().StringSliceVarP(&cfg.Repos, "repos", "r", cfg.Repos, reposDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.GiteaHost, "gitea-host", "", giteaHostDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.GiteaToken, "gitea-token", "", giteaTokenDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.GerritHost, "gerrit-host", "", gerritHostDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.GerritUser, "gerrit-user", "", gerritUserDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.GerritToken, "gerrit-token", "", gerritTokenDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.GitLabHost, "gitlab-host", "https://gitlab.com/api/v4/", gitLabHostDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.GitLabToken, "gitlab-token", "", gitLabTokenDesc)
 	digestCmd.PersistentFlags().StringVarP(&cfg.Before, "before", "b", defaultBeforeStr, fetchBeforeDesc)
 	digestCmd.PersistentFlags().StringVarP(&cfg.Since, "since", "s", defaultSinceStr, fetchSinceDesc)
 	digestCmd.PersistentFlags().StringVarP(&cfg.Token, "token", "t", cfg.Token, accessTokenDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.TokenFile, "token-file", cfg.TokenFile, tokenFileDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.TokenCommand, "token-command", cfg.TokenCommand, tokenCommandDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.TokenEnv, "token-env", "GITHUB_TOKEN", tokenEnvDesc)
+	digestCmd.PersistentFlags().StringToStringVar(&cfg.HostTokenEnv, "host-token-env", nil, hostTokenEnvDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.AppID, "app-id", cfg.AppID, appIDDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.AppKeyFile, "app-key", cfg.AppKeyFile, appKeyDesc)
+	digestCmd.PersistentFlags().Int64Var(&cfg.AppInstall, "app-installation", cfg.AppInstall, appInstallationDesc)
 	digestCmd.PersistentFlags().StringVarP(&cfg.Template, "template", "p", cfg.Template, templateDesc)
 	digestCmd.PersistentFlags().StringVarP(&cfg.OutDir, "outdir", "o", cfg.OutDir, outDirDesc)
 	digestCmd.PersistentFlags().BoolVar(&cfg.InlineStyles, "inline-styles", true, inlineStylesDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.Record, "record", cfg.Record, recordDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.Replay, "replay", cfg.Replay, replayDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.CacheDir, "cache", cfg.CacheDir, cacheDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.API, "api", "rest", apiDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.Select, "select", selectList, selectDesc)
+	digestCmd.PersistentFlags().StringSliceVar(&cfg.SearchLabels, "search-label", nil, searchLabelDesc)
+	digestCmd.PersistentFlags().StringSliceVar(&cfg.SearchAuthors, "search-author", nil, searchAuthorDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.SearchBase, "search-base", "", searchBaseDesc)
+	digestCmd.PersistentFlags().BoolVar(&cfg.Issues, "issues", false, issuesDesc)
+	digestCmd.PersistentFlags().StringSliceVar(&cfg.IncludeLabels, "include-label", nil, includeLabelDesc)
+	digestCmd.PersistentFlags().StringSliceVar(&cfg.ExcludeLabels, "exclude-label", nil, excludeLabelDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.StatsFile, "stats-file", "", statsFileDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.OnError, "on-error", onErrorFail, onErrorDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.CACert, "ca-cert", cfg.CACert, caCertDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.ClientCert, "client-cert", cfg.ClientCert, clientCertDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.ClientKey, "client-key", cfg.ClientKey, clientKeyDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.Proxy, "proxy", cfg.Proxy, proxyDesc)
+	digestCmd.PersistentFlags().BoolVar(&cfg.InsecureSkipVerify, "insecure-skip-verify", false, insecureSkipVerifyDesc)
+	digestCmd.PersistentFlags().StringVar(&cfg.ConfigFile, "config", cfg.ConfigFile, configFileDesc)
+	digestCmd.PersistentFlags().BoolVar(&cfg.CheckBudget, "check-budget", false, checkBudgetDesc)
+	digestCmd.PersistentFlags().DurationVar(&cfg.Timeout, "timeout", 0, timeoutDesc)
+	digestCmd.PersistentFlags().DurationVar(&cfg.RequestTimeout, "request-timeout", time.Minute, requestTimeoutDesc)
+	digestCmd.PersistentFlags().IntVar(&cfg.Concurrency, "concurrency", 4, concurrencyDesc)
 }
 
 // Run ...
@@ -17,15 +17,32 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"path"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// Policies for handling pull requests whose details cannot be fetched.
+const (
+	onErrorFail = "fail" // Abort the run
+	onErrorSkip = "skip" // Omit the pull request from the digest
+	onErrorMark = "mark" // Include the pull request, marked as incomplete
+)
+
+// Selections of the candidate pull requests for a digest.
+const (
+	selectList   = "list"   // List pull requests by descending update time
+	selectSearch = "search" // Search for pull requests updated since FetchSince
+)
+
 // TODO(spencer): combine this code with the code in stargazers
 //   for a single utility.
 
@@ -136,6 +153,91 @@ func (slice Subdirectories) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
+// Commit holds the message of a commit comprising a pull request.
+type Commit struct {
+	SHA    string `json:"sha"`
+	Commit struct {
+		Message string `json:"message"`
+		URL     string `json:"url"`
+	} `json:"commit"`
+}
+
+// Review states, as reported by GitHub. Other forges map their review
+// states onto these.
+const (
+	reviewApproved         = "APPROVED"
+	reviewChangesRequested = "CHANGES_REQUESTED"
+	reviewCommented        = "COMMENTED"
+	reviewDismissed        = "DISMISSED"
+	reviewPending          = "PENDING"
+)
+
+// Review holds a review of a pull request.
+type Review struct {
+	ID          int    `json:"id"`
+	User        User   `json:"user"`
+	State       string `json:"state"`
+	SubmittedAt string `json:"submitted_at"`
+	HtmlURL     string `json:"html_url"`
+}
+
+// Reviewer holds a reviewer of a pull request and the state of their
+// latest review.
+type Reviewer struct {
+	User  User
+	State string
+}
+
+// StateStr returns the state of the reviewer's latest review in
+// human-readable form.
+func (r *Reviewer) StateStr() string {
+	switch r.State {
+	case reviewApproved:
+		return "approved"
+	case reviewChangesRequested:
+		return "changes requested"
+	}
+	return "commented"
+}
+
+// Label holds a label applied to a pull request.
+type Label struct {
+	Name        string `json:"name"`
+	Color       string `json:"color"` // Hex RGB, without "#"
+	Description string `json:"description"`
+}
+
+// Milestone holds the milestone of a pull request.
+type Milestone struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	State   string `json:"state"`
+	DueOn   string `json:"due_on"`
+	HtmlURL string `json:"html_url"`
+}
+
+// Summarized states of a CI check.
+const (
+	checkSuccess = "success"
+	checkFailure = "failure"
+	checkPending = "pending"
+)
+
+// Check holds the result of a CI check of the head commit of a pull
+// request: either a commit status or a check run.
+type Check struct {
+	Name  string
+	State string // checkSuccess, checkFailure or checkPending
+	URL   string
+}
+
+// Branch holds a branch of a pull request and the commit it pointed
+// to.
+type Branch struct {
+	SHA string `json:"sha"`
+	Ref string `json:"ref"`
+}
+
 type PullRequest struct {
 	URL                string `json:"url"`
 	ID                 int    `json:"id"`
@@ -155,6 +257,7 @@ type PullRequest struct {
 	MergedAt           string `json:"merged_at"`
 	MergeCommitSHA     string `json:"merge_commit_sha"`
 	Assignee           User   `json:"assignee"`
+	Head               Branch `json:"head"`
 	CommitsURL         string `json:"commits_url"`
 	Review_commentsURL string `json:"review_comments_url"`
 	Review_commentURL  string `json:"review_comment_url"`
@@ -171,13 +274,17 @@ type PullRequest struct {
 	Deletions          int    `json:"deletions"`
 	ChangedFiles       int    `json:"changed_files"`
 
-	CommitMessages []struct {
-		Commit struct {
-			Message string `json:"message"`
-			URL     string `json:"url"`
-		} `json:"commit"`
-	}
-	Files []*File `json:"-"`
+	Labels    []*Label   `json:"labels"`
+	Milestone *Milestone `json:"milestone"`
+
+	Repo           string    `json:"-"` // Repository, as named in --repos
+	CommitMessages []*Commit `json:"-"`
+	Files          []*File   `json:"-"`
+	Reviews        []*Review `json:"-"` // In order of submission; nil if not supported by the forge
+	Checks         []*Check  `json:"-"` // CI checks of the head commit; nil if not supported by the forge
+	ClosedBy       User      `json:"-"` // Who closed the pull request without merging, if known
+	FilesTruncated bool      `json:"-"` // Not all changed files could be listed
+	FetchError     string    `json:"-"` // Why details could not be fetched, if they couldn't
 }
 
 // TotalChanges returns total of additions and deletions.
@@ -201,6 +308,132 @@ func (pr *PullRequest) CommentsStr() string {
 	return format(pr.Comments)
 }
 
+// ReviewsStr returns the number of reviews submitted by reviewers
+// other than the author, as counted by Reviewers.
+func (pr *PullRequest) ReviewsStr() string {
+	n := 0
+	for _, r := range pr.Reviews {
+		if r.State != reviewPending && r.User.Login != pr.User.Login {
+			n++
+		}
+	}
+	return format(n)
+}
+
+// Reviewers returns the reviewers of the pull request other than its
+// author, in order of their first review, with the state of their
+// latest review. As on GitHub, a comment doesn't supersede an approval
+// or a request for changes, while a dismissal does. Pending reviews
+// have yet to be submitted and are ignored.
+func (pr *PullRequest) Reviewers() []*Reviewer {
+	var reviewers []*Reviewer
+	byLogin := map[string]*Reviewer{}
+	for _, r := range pr.Reviews {
+		if r.State == reviewPending || r.User.Login == pr.User.Login {
+			continue
+		}
+		reviewer, ok := byLogin[r.User.Login]
+		if !ok {
+			reviewer = &Reviewer{User: r.User, State: reviewCommented}
+			reviewers = append(reviewers, reviewer)
+			byLogin[r.User.Login] = reviewer
+		}
+		switch r.State {
+		case reviewApproved, reviewChangesRequested:
+			reviewer.State = r.State
+		case reviewDismissed:
+			reviewer.State = reviewCommented
+		}
+	}
+	return reviewers
+}
+
+// ApprovedBy returns the reviewers whose latest review approved the
+// pull request.
+func (pr *PullRequest) ApprovedBy() []User {
+	var users []User
+	for _, r := range pr.Reviewers() {
+		if r.State == reviewApproved {
+			users = append(users, r.User)
+		}
+	}
+	return users
+}
+
+// MergedWithoutApproval returns whether the pull request was merged
+// without any reviewer's approval standing. Pull requests whose reviews
+// are unknown aren't flagged.
+func (pr *PullRequest) MergedWithoutApproval() bool {
+	return pr.Merged && pr.Reviews != nil && len(pr.ApprovedBy()) == 0
+}
+
+// IsMerged returns whether the pull request was merged. Listings
+// report only the time of merging, which is empty unless merged.
+func (pr *PullRequest) IsMerged() bool {
+	return pr.Merged || len(pr.MergedAt) > 0
+}
+
+// Closer returns the user who closed the pull request: the user who
+// merged it, or else the user who closed it without merging.
+func (pr *PullRequest) Closer() User {
+	if pr.IsMerged() {
+		return pr.MergedBy
+	}
+	return pr.ClosedBy
+}
+
+// HasLabel returns whether the pull request has the label.
+func (pr *PullRequest) HasLabel(name string) bool {
+	return hasLabel(pr.Labels, name)
+}
+
+// hasLabel returns whether the labels include the named label. As on
+// GitHub, label names are compared case-insensitively.
+func hasLabel(labels []*Label, name string) bool {
+	for _, l := range labels {
+		if strings.EqualFold(l.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// CIState returns "failing" if any CI check of the head commit failed,
+// "pending" if any has yet to complete, or "passing" if all succeeded.
+// Returns the empty string if the head commit has no checks.
+func (pr *PullRequest) CIState() string {
+	if len(pr.Checks) == 0 {
+		return ""
+	}
+	state := "passing"
+	for _, ch := range pr.Checks {
+		switch ch.State {
+		case checkFailure:
+			return "failing"
+		case checkPending:
+			state = "pending"
+		}
+	}
+	return state
+}
+
+// FailingChecks returns the CI checks of the head commit which failed.
+func (pr *PullRequest) FailingChecks() []*Check {
+	var failing []*Check
+	for _, ch := range pr.Checks {
+		if ch.State == checkFailure {
+			failing = append(failing, ch)
+		}
+	}
+	return failing
+}
+
+// MergedOnRed returns whether the pull request was merged although CI
+// checks of its head commit failed.
+func (pr *PullRequest) MergedOnRed() bool {
+	return pr.Merged && pr.CIState() == "failing"
+}
+
 // Subdirectories returns a sorted slice of subdirectories which include
 // changed files, sorted by number of changes. Only the subdirectories
 // which comprise <=80% of the total changes are returned.
@@ -269,55 +502,104 @@ func (pr *PullRequest) ClosedAtStr() string {
 }
 
 // Queries pull requests for the repository. Returns a slice each for
-// open and closed pull requests.
-func Query(c *Config) (open, closed []*PullRequest, err error) {
+// open, merged and abandoned (closed without merging) pull requests.
+func Query(ctx context.Context, c *Config) (open, merged, abandoned []*PullRequest, err error) {
+	endList := c.stats.phase("list")
 	for _, repo := range c.Repos {
-		var os []*PullRequest
-		var cs []*PullRequest
-		os, cs, err = QueryPullRequests(c, repo)
+		var os, ms, as []*PullRequest
+		os, ms, as, err = QueryPullRequests(ctx, c, repo)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		open = append(open, os...)
-		closed = append(closed, cs...)
+		merged = append(merged, ms...)
+		abandoned = append(abandoned, as...)
 	}
-	if err = QueryDetailedPullRequests(c, open); err != nil {
-		return nil, nil, err
+	endList()
+	if c.CheckBudget {
+		all := append(append(append([]*PullRequest(nil), open...), merged...), abandoned...)
+		if err = checkBudget(c, all); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	defer c.stats.phase("details")()
+	if open, err = QueryDetailedPullRequests(ctx, c, open); err != nil {
+		return nil, nil, nil, err
+	}
+	if merged, err = QueryDetailedPullRequests(ctx, c, merged); err != nil {
+		return nil, nil, nil, err
 	}
-	if err = QueryDetailedPullRequests(c, closed); err != nil {
-		return nil, nil, err
+	if abandoned, err = QueryDetailedPullRequests(ctx, c, abandoned); err != nil {
+		return nil, nil, nil, err
 	}
-	return open, closed, nil
+	return open, merged, abandoned, nil
 }
 
 // QueryPullRequests queries all pull requests from the repo or a
-// day's worth, whichever is greater.
-func QueryPullRequests(c *Config, repo string) ([]*PullRequest, []*PullRequest, error) {
+// day's worth, whichever is greater. Candidates are listed or, with
+// --select=search, searched for. Returns a slice each for open, merged
+// and abandoned pull requests.
+func QueryPullRequests(ctx context.Context, c *Config, repo string) ([]*PullRequest, []*PullRequest, []*PullRequest, error) {
 	log.Printf("querying pull requests from %s opened or closed after %s\n", repo, c.FetchSince.Format(time.RFC3339))
-	url := fmt.Sprintf("%srepos/%s/pulls?state=all&sort=updated&direction=desc", c.Host, repo)
-	open, closed := []*PullRequest{}, []*PullRequest{}
+	opts := ListOptions{Sort: "updated", Since: c.FetchSince}
+	forge, name, err := c.forgeFor(repo)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx = withRepo(ctx, name)
+	list := forge.ListPullRequests
+	if c.Select == selectSearch {
+		s, ok := forge.(Searcher)
+		if !ok {
+			return nil, nil, nil, errors.New(fmt.Sprintf("%s does not support --select=search", repo))
+		}
+		query := searchQuery(c, name)
+		log.Printf("searching for %q\n", query)
+		list = func(ctx context.Context, repo string, opts ListOptions) ([]*PullRequest, Page, error) {
+			return s.SearchPullRequests(ctx, repo, query, opts)
+		}
+	}
+	// Release data retained for pull requests outside the digest.
+	release := func(prs ...*PullRequest) {}
+	if r, ok := forge.(Releaser); ok {
+		release = func(prs ...*PullRequest) {
+			for _, pr := range prs {
+				r.Release(pr)
+			}
+		}
+	}
+	open, merged, abandoned := []*PullRequest{}, []*PullRequest{}, []*PullRequest{}
 	total := 0
-	var err error
 	var done bool
-	fmt.Println("*** 0 open 0 closed, 0 total pull requests")
-	for len(url) > 0 && !done {
-		fetched := []*PullRequest{}
-		url, err = fetchURL(c, url, &fetched)
+	fmt.Println("*** 0 open 0 merged 0 abandoned, 0 total pull requests")
+	for first := true; (first || len(opts.Page) > 0) && !done; first = false {
+		fetched, page, err := list(ctx, name, opts)
 		if err != nil {
-			return nil, nil, err
+			if ctx.Err() != nil {
+				log.Printf("interrupted after listing %s pull requests from %s\n", format(total), repo)
+			}
+			return nil, nil, nil, err
 		}
+		opts.Page = page.Next
 		total += len(fetched)
-		for _, pr := range fetched {
+		for i, pr := range fetched {
 			// Break out of loop if updated timestamp is <= FetchSince.
 			t, err := time.Parse(time.RFC3339, pr.UpdatedAt)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 			if !c.FetchSince.Before(t) {
+				release(fetched[i:]...)
 				done = true
 				break
 			}
 
+			pr.Repo = repo
+			if !labelsMatch(c, pr.Labels) {
+				release(pr)
+				continue
+			}
+
 			var date string
 			switch pr.State {
 			case "open":
@@ -325,68 +607,229 @@ func QueryPullRequests(c *Config, repo string) ([]*PullRequest, []*PullRequest,
 			case "closed":
 				date = pr.ClosedAt
 			default:
+				release(pr)
 				continue
 			}
 			t, err = time.Parse(time.RFC3339, date)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
-			if pr.State == "open" {
-				if c.FetchSince.Before(t) {
+			if c.FetchSince.Before(t) {
+				switch {
+				case pr.State == "open":
 					open = append(open, pr)
+				case pr.IsMerged():
+					merged = append(merged, pr)
+				default:
+					abandoned = append(abandoned, pr)
 				}
 			} else {
-				if c.FetchSince.Before(t) {
-					closed = append(closed, pr)
-				}
+				release(pr)
 			}
-			fmt.Printf("\r*** %s open %s closed %s total pull requests\n", format(len(open)), format(len(closed)), format(total))
+			fmt.Printf("\r*** %s: %s open %s merged %s abandoned %s total pull requests\n",
+				page, format(len(open)), format(len(merged)), format(len(abandoned)), format(total))
 		}
 	}
 	fmt.Printf("\n")
-	return open, closed, nil
+	return open, merged, abandoned, nil
+}
+
+// labelsMatch returns whether the labels of a pull request or issue
+// pass the --include-label and --exclude-label filters: they must
+// include at least one of the included labels, if any are specified,
+// and none of the excluded labels.
+func labelsMatch(c *Config, labels []*Label) bool {
+	for _, label := range c.ExcludeLabels {
+		if hasLabel(labels, label) {
+			return false
+		}
+	}
+	if len(c.IncludeLabels) == 0 {
+		return true
+	}
+	for _, label := range c.IncludeLabels {
+		if hasLabel(labels, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchQuery returns the query selecting the pull requests of repo
+// updated since c.FetchSince and matching the --search-* qualifiers.
+func searchQuery(c *Config, repo string) string {
+	qualifiers := []string{
+		"is:pr",
+		"repo:" + repo,
+		"updated:>=" + c.FetchSince.UTC().Format("2006-01-02T15:04:05+00:00"),
+	}
+	for _, label := range c.SearchLabels {
+		qualifiers = append(qualifiers, fmt.Sprintf("label:%q", label))
+	}
+	for _, author := range c.SearchAuthors {
+		qualifiers = append(qualifiers, "author:"+author)
+	}
+	if len(c.SearchBase) > 0 {
+		qualifiers = append(qualifiers, "base:"+c.SearchBase)
+	}
+	return strings.Join(qualifiers, " ")
 }
 
 // QueryDetailedPullRequests queries detailed info on each pull request
-// in the provided slice.
-func QueryDetailedPullRequests(c *Config, prs []*PullRequest) error {
+// in the provided slice, using up to c.Concurrency concurrent workers.
+// Each pull request is updated in place, so the order of the slice is
+// unaffected by the order in which the requests complete.
+//
+// Failures are handled according to c.OnError: the first error is
+// returned ("fail"), or the failure is recorded in c.Failures and the
+// pull request is either omitted from the returned slice ("skip") or
+// kept with its FetchError set ("mark").
+func QueryDetailedPullRequests(ctx context.Context, c *Config, prs []*PullRequest) ([]*PullRequest, error) {
 	log.Printf("querying detailed info for each of %s pull requests...\n", format(len(prs)))
 	fmt.Println("*** detailed info for 0 pull requests")
-	for i, pr := range prs {
-		// Fetch detailed pull request info.
-		if _, err := fetchURL(c, pr.URL, pr); err != nil {
+	workers := c.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+	var completed int
+	work := make(chan *PullRequest)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pr := range work {
+				err := queryDetailedPullRequest(ctx, c, pr)
+				mu.Lock()
+				switch {
+				case err == nil:
+					completed++
+					fmt.Printf("\r*** detailed info for %s pull requests\n", format(completed))
+				case c.OnError == onErrorFail || ctx.Err() != nil:
+					if firstErr == nil {
+						firstErr = err
+					}
+				default:
+					log.Printf("unable to fetch details for %s: %s\n", pr.HtmlURL, err)
+					pr.FetchError = err.Error()
+					c.Failures = append(c.Failures, pr)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, pr := range prs {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		select {
+		case work <- pr:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+	fmt.Printf("\n")
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		if ctx.Err() != nil {
+			log.Printf("interrupted after fetching detailed info for %s of %s pull requests\n", format(completed), format(len(prs)))
+		}
+		return nil, firstErr
+	}
+	if c.OnError == onErrorSkip {
+		fetched := make([]*PullRequest, 0, len(prs))
+		for _, pr := range prs {
+			if len(pr.FetchError) == 0 {
+				fetched = append(fetched, pr)
+			}
+		}
+		prs = fetched
+	}
+	return prs, nil
+}
+
+// queryDetailedPullRequest queries detailed info, commits, reviews, CI
+// checks and changed files for a single pull request. Reviews and CI
+// checks of pull requests closed without merging aren't shown, so they
+// are skipped in favor of who closed them.
+func queryDetailedPullRequest(ctx context.Context, c *Config, pr *PullRequest) error {
+	forge, name, err := c.forgeFor(pr.Repo)
+	if err != nil {
+		return err
+	}
+	ctx = withRepo(ctx, name)
+	if r, ok := forge.(Releaser); ok {
+		defer r.Release(pr)
+	}
+	// Fetch detailed pull request info.
+	if err := forge.GetPullRequest(ctx, pr); err != nil {
+		return err
+	}
+	// Fetch commit messages.
+	commits, err := forge.ListCommits(ctx, pr)
+	if err != nil {
+		return err
+	}
+	pr.CommitMessages = commits
+	abandoned := pr.State == "closed" && !pr.IsMerged()
+	// Fetch reviews, if the forge supports them.
+	if rl, ok := forge.(ReviewLister); ok && !abandoned {
+		if pr.Reviews, err = rl.ListReviews(ctx, pr); err != nil {
 			return err
 		}
-		// Fetch commit messages.
-		if _, err := fetchURL(c, pr.URL+"/commits", &pr.CommitMessages); err != nil {
+	}
+	// Fetch who closed the pull request without merging it, if the
+	// forge doesn't report it with the pull request.
+	if cf, ok := forge.(CloserFinder); ok && abandoned && len(pr.ClosedBy.Login) == 0 {
+		if pr.ClosedBy, err = cf.FindCloser(ctx, pr); err != nil {
 			return err
 		}
-		// Fetch files changed by pull request.
-		if _, err := fetchURL(c, pr.URL+"/files", &pr.Files); err != nil {
+	}
+	// Fetch CI checks of the head commit, if the forge supports them.
+	if cl, ok := forge.(CheckLister); ok && !abandoned && len(pr.Head.SHA) > 0 {
+		if pr.Checks, err = cl.ListChecks(ctx, pr); err != nil {
 			return err
 		}
-		// Remove files we're supposed to ignore.
-		newFiles := []*File{}
-		for _, f := range pr.Files {
-			if !skipFile(f.Filename) {
-				newFiles = append(newFiles, f)
-			}
+	}
+	// Fetch files changed by pull request.
+	files, err := forge.ListFiles(ctx, pr)
+	if err != nil {
+		return err
+	}
+	// Listings are capped (at 3000 files for GitHub), so the changed
+	// files of a very large pull request may be incomplete.
+	pr.FilesTruncated = len(files) < pr.ChangedFiles
+	// Remove files we're supposed to ignore.
+	newFiles := []*File{}
+	for _, f := range files {
+		if !skipFile(f.Filename) {
+			newFiles = append(newFiles, f)
 		}
-		pr.Files = newFiles
-		fmt.Printf("\r*** detailed info for %s pull requests\n", format(i+1))
 	}
-	fmt.Printf("\n")
+	pr.Files = newFiles
 	return nil
 }
 
-func CountMonthly(c *Config) ([]int, error) {
+func CountMonthly(ctx context.Context, c *Config) ([]int, error) {
+	defer c.stats.phase("list")()
 	var counts []int
 	for t := c.Now; !t.Before(c.FetchSince); {
 		counts = append(counts, 0)
 		t = t.AddDate(0, -1, 0)
 	}
 	for _, repo := range c.Repos {
-		if err := CountMonthlyPullRequests(c, repo, counts); err != nil {
+		if err := CountMonthlyPullRequests(ctx, c, repo, counts); err != nil {
 			return nil, err
 		}
 	}
@@ -395,9 +838,14 @@ func CountMonthly(c *Config) ([]int, error) {
 
 // CountMonthlyPullRequests queries all pull requests by created data
 // and adds counts to the specified counts slice by month.
-func CountMonthlyPullRequests(c *Config, repo string, counts []int) error {
+func CountMonthlyPullRequests(ctx context.Context, c *Config, repo string, counts []int) error {
 	log.Printf("counting monthly pull requests from %s after %s", repo, c.FetchSince.Format(time.RFC3339))
-	url := fmt.Sprintf("%srepos/%s/pulls?state=all&sort=created&direction=desc", c.Host, repo)
+	opts := ListOptions{Sort: "created", Since: c.FetchSince, Count: true}
+	forge, name, err := c.forgeFor(repo)
+	if err != nil {
+		return err
+	}
+	ctx = withRepo(ctx, name)
 
 	var idx int
 	var monthTotal int
@@ -414,13 +862,18 @@ func CountMonthlyPullRequests(c *Config, repo string, counts []int) error {
 		}
 	}
 
-	for done := false; len(url) > 0 && !done; {
-		fetched := []*PullRequest{}
-		var err error
-		url, err = fetchURL(c, url, &fetched)
+	r, _ := forge.(Releaser)
+	for first, done := true, false; (first || len(opts.Page) > 0) && !done; first = false {
+		fetched, page, err := forge.ListPullRequests(ctx, name, opts)
 		if err != nil {
 			return err
 		}
+		// Details of counted pull requests aren't queried.
+		for _, pr := range fetched {
+			if r != nil {
+				r.Release(pr)
+			}
+		}
 		for _, pr := range fetched {
 			prT, err := time.Parse(time.RFC3339, pr.CreatedAt)
 			if err != nil {
@@ -431,9 +884,13 @@ func CountMonthlyPullRequests(c *Config, repo string, counts []int) error {
 				done = true
 				break
 			}
+			if !labelsMatch(c, pr.Labels) {
+				continue
+			}
 			fillCounts(prT)
 			monthTotal++
 		}
+		opts.Page = page.Next
 	}
 	fillCounts(c.FetchSince)
 	counts[idx] += monthTotal
@@ -0,0 +1,299 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// paceFraction is the fraction of a rate limit budget below which
+// requests are paced so as to spread the remaining budget evenly
+// until the limit resets.
+const paceFraction = 0.1
+
+// secondaryRateLimitWait is how long to hold requests after exceeding
+// a secondary rate limit if the response doesn't specify Retry-After.
+const secondaryRateLimitWait = time.Minute
+
+// A secondaryRateLimitError is returned when the requestor has
+// exceeded one of GitHub's secondary (abuse) rate limits, which guard
+// against excessive concurrency or request rates.
+type secondaryRateLimitError struct {
+	retryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *secondaryRateLimitError) Error() string {
+	return fmt.Sprintf("secondary rate limit for GitHub API access has been exceeded; retrying in %s", e.retryAfter)
+}
+
+// rateBudget is the state of a rate limit as of the most recent
+// response.
+type rateBudget struct {
+	limit     int
+	remaining int
+	reset     time.Time // Time at which the budget is replenished
+	next      time.Time // Earliest time of the next request if pacing
+	hold      time.Time // Requests are held until this time
+}
+
+// budgetID identifies the rate limit budget of a credential.
+type budgetID struct {
+	key        string // See budgetKey
+	credential string // Digest of the Authorization header, if any
+}
+
+// rateLimiter coordinates the rate limit budgets shared by concurrent
+// requests. Each credential (access token, installation token or
+// forge account) has its own budget for each API resource ("core",
+// "graphql", "search") of each host, tracked from the X-RateLimit
+// headers of every response, and requests are paced once a budget
+// runs low. Once a request is refused for exceeding a rate limit,
+// requests made with the same credential to the same resource are held
+// until the limit resets or, for secondary limits, for the time
+// requested by the server. The zero value is ready for use.
+type rateLimiter struct {
+	mu      sync.Mutex
+	budgets map[budgetID]*rateBudget
+}
+
+// budgetKey returns the key of the rate limit budget for the API
+// resource on the host of the URL, e.g. "api.github.com/core". Each
+// GitHub instance has its own budgets.
+func budgetKey(rawURL, resource string) string {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && len(u.Host) > 0 {
+		host = u.Host
+	}
+	return host + "/" + resource
+}
+
+// rateLimitResource returns the API resource whose rate limit applies
+// to req.
+func rateLimitResource(req *http.Request) string {
+	switch path := req.URL.Path; {
+	case strings.HasSuffix(path, "/graphql"):
+		return "graphql"
+	case strings.Contains(path, "/search/"):
+		return "search"
+	}
+	return "core"
+}
+
+// newBudgetID returns the ID of the budget of the resource which req
+// draws on. Credentials are identified by a digest, so that they're
+// never retained in the clear.
+func newBudgetID(req *http.Request, resource string) budgetID {
+	id := budgetID{key: budgetKey(req.URL.String(), resource)}
+	if auth := req.Header.Get("Authorization"); len(auth) > 0 {
+		sum := sha256.Sum256([]byte(auth))
+		id.credential = hex.EncodeToString(sum[:8])
+	}
+	return id
+}
+
+// budgetFor returns the budget identified by id, creating it if
+// necessary. rl.mu must be held.
+func (rl *rateLimiter) budgetFor(id budgetID) *rateBudget {
+	if rl.budgets == nil {
+		rl.budgets = map[budgetID]*rateBudget{}
+	}
+	b, ok := rl.budgets[id]
+	if !ok {
+		b = &rateBudget{}
+		rl.budgets[id] = b
+	}
+	return b
+}
+
+// wait blocks until the request, authorized as it will be made, may be
+// made or the context is done. Requests are paced once the budget runs
+// low, unless pace is false (as when other tokens may be rotated to
+// once this one is exhausted).
+func (rl *rateLimiter) wait(ctx context.Context, req *http.Request, pace bool) error {
+	rl.mu.Lock()
+	b := rl.budgetFor(newBudgetID(req, rateLimitResource(req)))
+	start := b.hold
+	now := time.Now()
+	if pace && b.reset.After(now) && float64(b.remaining) < paceFraction*float64(b.limit) {
+		// Reserve the next slot in an even spread of the remaining
+		// budget over the time until it resets.
+		if b.next.Before(now) {
+			b.next = now
+		}
+		if b.next.After(start) {
+			start = b.next
+		}
+		b.next = b.next.Add(b.reset.Sub(now) / time.Duration(b.remaining+1))
+	}
+	rl.mu.Unlock()
+	return sleep(ctx, time.Until(start))
+}
+
+// observe updates the budget of the resource from the X-RateLimit
+// headers of the response to req. Responses from concurrent requests
+// may arrive out of order, so the lowest remaining count seen within a
+// rate limit regime prevails.
+func (rl *rateLimiter) observe(req *http.Request, header http.Header) {
+	limit, err1 := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	resetUnix, err3 := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+	resource := header.Get("X-RateLimit-Resource")
+	if len(resource) == 0 {
+		resource = "core"
+	}
+	reset := time.Unix(resetUnix, 0)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b := rl.budgetFor(newBudgetID(req, resource))
+	if reset.Before(b.reset) || (reset.Equal(b.reset) && remaining >= b.remaining) {
+		return
+	}
+	b.limit, b.remaining, b.reset = limit, remaining, reset
+}
+
+// keys returns the sorted keys of the budgets reported by responses.
+func (rl *rateLimiter) keys() []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	seen := map[string]bool{}
+	keys := []string{}
+	for id, b := range rl.budgets {
+		if !seen[id.key] && !b.reset.IsZero() {
+			seen[id.key] = true
+			keys = append(keys, id.key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// budget returns the remaining budget for the key (see budgetKey),
+// totaled over the credentials used, and the earliest time at which one
+// of their budgets resets. Returns false if no response has reported
+// it.
+func (rl *rateLimiter) budget(key string) (int, time.Time, bool) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	var remaining int
+	var reset time.Time
+	var ok bool
+	for id, b := range rl.budgets {
+		if id.key != key || !b.reset.After(now) {
+			continue
+		}
+		remaining += b.remaining
+		if !ok || b.reset.Before(reset) {
+			reset = b.reset
+		}
+		ok = true
+	}
+	return remaining, reset, ok
+}
+
+// exceeded records that req exceeded the rate limit, holding requests
+// made with its credential to the same resource until the limit regime
+// expires.
+func (rl *rateLimiter) exceeded(req *http.Request, rle *rateLimitError) {
+	rl.holdFor(req, rle.expiration())
+}
+
+// holdFor holds requests made with the credential of req to the same
+// resource for the specified duration.
+func (rl *rateLimiter) holdFor(req *http.Request, d time.Duration) {
+	hold := time.Now().Add(d)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	b := rl.budgetFor(newBudgetID(req, rateLimitResource(req)))
+	if hold.After(b.hold) {
+		b.hold = hold
+	}
+}
+
+// sleep pauses for the specified duration, returning early with the
+// context's error if it's done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// A costEstimator is a Forge able to estimate the rate limited
+// requests needed to fetch the details of pull requests.
+type costEstimator interface {
+	// estimateCost returns the key of the rate limit budget which applies
+	// (see budgetKey) and the estimated number of requests.
+	estimateCost(prs []*PullRequest) (key string, requests int)
+}
+
+// checkBudget returns an error if the estimated cost of fetching the
+// details of the pull requests exceeds the remaining rate limit budget
+// of any forge. The estimate ignores cache hits, but not knowing the
+// lengths of lists of commits and files, counts only their first pages.
+// Only the pull requests of GitHub repositories are rate limited.
+func checkBudget(c *Config, prs []*PullRequest) error {
+	var forges []Forge
+	byForge := map[Forge][]*PullRequest{}
+	for _, pr := range prs {
+		f, _, err := c.forgeFor(pr.Repo)
+		if err != nil {
+			return err
+		}
+		if _, ok := byForge[f]; !ok {
+			forges = append(forges, f)
+		}
+		byForge[f] = append(byForge[f], pr)
+	}
+	for _, f := range forges {
+		ce, ok := f.(costEstimator)
+		if !ok {
+			continue
+		}
+		key, cost := ce.estimateCost(byForge[f])
+		remaining, reset, ok := c.limiter.budget(key)
+		if !ok || cost <= remaining {
+			continue
+		}
+		return errors.New(fmt.Sprintf("fetching details for %d pull requests requires an estimated %d requests, "+
+			"but only %d remain in the %q rate limit budget until %s", len(byForge[f]), cost, remaining, key, reset.Local()))
+	}
+	return nil
+}
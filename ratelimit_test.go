@@ -0,0 +1,102 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestRequest(t *testing.T, url, token string) *http.Request {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "token "+token)
+	}
+	return req
+}
+
+func rateLimitHeader(limit, remaining int, reset time.Time) http.Header {
+	h := http.Header{}
+	h.Set("X-RateLimit-Limit", strconv.Itoa(limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	return h
+}
+
+// waitFor returns how long wait blocks for req, up to a second.
+func waitFor(t *testing.T, rl *rateLimiter, req *http.Request, pace bool) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := rl.wait(ctx, req, pace); err != nil && err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+func TestRateLimiterBudgetsPerToken(t *testing.T) {
+	var rl rateLimiter
+	reset := time.Now().Add(time.Hour)
+	const url = "https://api.github.com/repos/o/r/pulls"
+	rl.observe(newTestRequest(t, url, "a"), rateLimitHeader(5000, 400, reset))
+	rl.observe(newTestRequest(t, url, "b"), rateLimitHeader(5000, 4999, reset.Add(-time.Minute)))
+
+	// Token b has plenty of budget; token a's low budget doesn't pace it.
+	for i := 0; i < 10; i++ {
+		if d := waitFor(t, &rl, newTestRequest(t, url, "b"), true); d > 100*time.Millisecond {
+			t.Fatalf("request %d with token b waited %s", i, d)
+		}
+	}
+	// Token a is paced, unless other tokens remain to rotate to.
+	waitFor(t, &rl, newTestRequest(t, url, "a"), true)
+	if d := waitFor(t, &rl, newTestRequest(t, url, "a"), true); d < 100*time.Millisecond {
+		t.Errorf("expected token a to be paced; waited %s", d)
+	}
+	if d := waitFor(t, &rl, newTestRequest(t, url, "a"), false); d > 100*time.Millisecond {
+		t.Errorf("expected token a not to be paced; waited %s", d)
+	}
+
+	if remaining, _, ok := rl.budget("api.github.com/core"); !ok || remaining != 5399 {
+		t.Errorf("expected 5399 remaining; got %d (%t)", remaining, ok)
+	}
+}
+
+func TestRateLimiterHoldsPerHostAndToken(t *testing.T) {
+	var rl rateLimiter
+	held := newTestRequest(t, "https://api.github.com/repos/o/r/pulls", "a")
+	rl.exceeded(held, &rateLimitError{resetUnix: time.Now().Add(time.Hour).Unix()})
+
+	if d := waitFor(t, &rl, held, true); d < 500*time.Millisecond {
+		t.Errorf("expected token a to be held; waited %s", d)
+	}
+	for _, req := range []*http.Request{
+		newTestRequest(t, "https://api.github.com/repos/o/r/pulls", "b"),
+		newTestRequest(t, "https://api.github.com/search/issues", "a"),
+		newTestRequest(t, "https://ghe.example.com/api/v3/repos/o/r/pulls", "a"),
+		newTestRequest(t, "https://gitlab.com/api/v4/projects/1/merge_requests", ""),
+	} {
+		if d := waitFor(t, &rl, req, true); d > 100*time.Millisecond {
+			t.Errorf("%s waited %s", req.URL, d)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A recordedResponse is the on-disk form of an HTTP exchange saved via
+// --record and served back via --replay, and of a responseCache entry.
+// Request headers are not saved and tokens in responses are redacted,
+// so access tokens never end up on disk.
+type recordedResponse struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+}
+
+// recordingPath returns the filename within dir under which the
+// response to req is saved. The name is a hash of the method, URL and
+// request body, so that each distinct request maps to one file.
+func recordingPath(dir string, req *http.Request) (string, error) {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		b, err := ioutil.ReadAll(body)
+		if err != nil {
+			return "", err
+		}
+		h.Write(b)
+	}
+	return filepath.Join(dir, hex.EncodeToString(h.Sum(nil))+".json"), nil
+}
+
+// A replayError is returned when replaying and no response was
+// recorded for a request.
+type replayError struct {
+	method, url string
+}
+
+// Error implements the error interface.
+func (e *replayError) Error() string {
+	return fmt.Sprintf("no recorded response for %s %s", e.method, e.url)
+}
+
+// recordingTransport saves every response received through the
+// underlying transport to a directory.
+type recordingTransport struct {
+	dir  string
+	base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, err := recordingPath(t.dir, req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	rr := recordedResponse{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       string(redactTokens(req, body)),
+	}
+	data, err := json.MarshalIndent(rr, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// redactedToken replaces access tokens in recorded responses.
+const redactedToken = "REDACTED"
+
+// redactTokens returns body with the token of a GitHub App installation
+// access token response replaced by redactedToken. Other responses are
+// returned unmodified.
+func redactTokens(req *http.Request, body []byte) []byte {
+	if !strings.HasSuffix(req.URL.Path, "/access_tokens") {
+		return body
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		// Don't risk saving a token which can't be found.
+		return nil
+	}
+	if _, ok := fields["token"]; !ok {
+		return body
+	}
+	fields["token"], _ = json.Marshal(redactedToken)
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// replayTransport serves responses previously saved by a
+// recordingTransport, without any network access.
+type replayTransport struct {
+	dir string
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path, err := recordingPath(t.dir, req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, &replayError{method: req.Method, url: req.URL.String()}
+	} else if err != nil {
+		return nil, err
+	}
+	var rr recordedResponse
+	if err := json.Unmarshal(data, &rr); err != nil {
+		return nil, fmt.Errorf("unmarshal recording %q: %s", path, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rr.StatusCode, http.StatusText(rr.StatusCode)),
+		StatusCode:    rr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        rr.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(rr.Body))),
+		ContentLength: int64(len(rr.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// numberRegexp matches the numeric path segments of API URLs, such as
+// pull request numbers and installation IDs.
+var numberRegexp = regexp.MustCompile(`/[0-9]+(/|$)`)
+
+// shaRegexp matches a path segment which is a commit SHA.
+var shaRegexp = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// runStats accumulates statistics on the API requests made during a
+// run and the time spent in each of its phases. The zero value is
+// ready for use.
+type runStats struct {
+	mu        sync.Mutex
+	requests  map[string]int // Requests (including retries) by endpoint
+	retries   int            // Requests repeating a failed attempt
+	cacheHits int            // 304 (Not Modified) responses
+	bytes     int64          // Response body bytes transferred
+	held      wallTimer      // Requests held by rate limits
+	backoff   wallTimer      // Requests backing off before a retry
+	phases    []phaseStats   // Phases of the run, in order
+}
+
+// wallTimer measures the wall time during which at least one of any
+// number of concurrent activities was in progress, so that time during
+// which activities overlap is counted once.
+type wallTimer struct {
+	active int           // Activities in progress
+	since  time.Time     // Start of the current period of activity
+	total  time.Duration // Completed periods of activity
+}
+
+// start records the start of an activity at now.
+func (w *wallTimer) start(now time.Time) {
+	if w.active == 0 {
+		w.since = now
+	}
+	w.active++
+}
+
+// stop records the end of an activity at now.
+func (w *wallTimer) stop(now time.Time) {
+	if w.active--; w.active == 0 {
+		w.total += now.Sub(w.since)
+	}
+}
+
+// elapsed returns the wall time of activity up to now.
+func (w *wallTimer) elapsed(now time.Time) time.Duration {
+	if w.active > 0 {
+		return w.total + now.Sub(w.since)
+	}
+	return w.total
+}
+
+// phaseStats is the wall time of a phase of the run.
+type phaseStats struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// endpoint returns the endpoint of req for the per-endpoint request
+// counts, e.g. "GET /repos/:owner/:repo/pulls/:number/files".
+func endpoint(req *http.Request) string {
+	segments := strings.Split(req.URL.EscapedPath(), "/")
+	for i := range segments {
+		if segments[i] == "repos" && i+2 < len(segments) {
+			segments[i+1], segments[i+2] = ":owner", ":repo"
+		} else if segments[i] == "projects" && i+1 < len(segments) {
+			segments[i+1] = ":project"
+		} else if shaRegexp.MatchString(segments[i]) {
+			segments[i] = ":sha"
+		}
+	}
+	path := strings.Join(segments, "/")
+	for numberRegexp.MatchString(path) {
+		path = numberRegexp.ReplaceAllString(path, "/:number$1")
+	}
+	return req.Method + " " + path
+}
+
+// request records an attempt to make req; attempts after the first
+// are retries.
+func (s *runStats) request(req *http.Request, attempt uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.requests == nil {
+		s.requests = map[string]int{}
+	}
+	s.requests[endpoint(req)]++
+	if attempt > 0 {
+		s.retries++
+	}
+}
+
+// response records a response body of n bytes, or a cache hit.
+func (s *runStats) response(n int, cacheHit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes += int64(n)
+	if cacheHit {
+		s.cacheHits++
+	}
+}
+
+// hold records that a request is held by rate limits, returning a
+// function which ends the hold.
+func (s *runStats) hold() func() {
+	return s.track(&s.held)
+}
+
+// backOff records that a request is backing off before a retry,
+// returning a function which ends the backoff.
+func (s *runStats) backOff() func() {
+	return s.track(&s.backoff)
+}
+
+// track starts an activity timed by w, returning a function which
+// ends it.
+func (s *runStats) track(w *wallTimer) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.start(time.Now())
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.stop(time.Now())
+	}
+}
+
+// phase starts timing the named phase of the run, returning a function
+// which ends it.
+func (s *runStats) phase(name string) func() {
+	start := time.Now()
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.phases = append(s.phases, phaseStats{Name: name, Duration: time.Since(start)})
+	}
+}
+
+// statsReport is the summary of a run written by --stats-file.
+type statsReport struct {
+	Requests   map[string]int        `json:"requests"`
+	Total      int                   `json:"total_requests"`
+	Retries    int                   `json:"retries"`
+	CacheHits  int                   `json:"cache_hits"`
+	Bytes      int64                 `json:"bytes"`
+	Held       time.Duration         `json:"rate_limit_wait_ns"`
+	Backoff    time.Duration         `json:"backoff_ns"`
+	RateLimits map[string]rateReport `json:"rate_limits"`
+	Phases     []phaseStats          `json:"phases"`
+}
+
+// rateReport is the state of a rate limit at the end of a run.
+type rateReport struct {
+	Remaining int       `json:"remaining"`
+	Reset     time.Time `json:"reset"`
+}
+
+// report returns the summary of the run, including the rate limit
+// budgets remaining according to the limiter.
+func (s *runStats) report(rl *rateLimiter) *statsReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	r := &statsReport{
+		Requests:   map[string]int{},
+		Retries:    s.retries,
+		CacheHits:  s.cacheHits,
+		Bytes:      s.bytes,
+		Held:       s.held.elapsed(now),
+		Backoff:    s.backoff.elapsed(now),
+		RateLimits: map[string]rateReport{},
+		Phases:     append([]phaseStats(nil), s.phases...),
+	}
+	for e, n := range s.requests {
+		r.Requests[e] = n
+		r.Total += n
+	}
+	for _, key := range rl.keys() {
+		if remaining, reset, ok := rl.budget(key); ok {
+			r.RateLimits[key] = rateReport{Remaining: remaining, Reset: reset}
+		}
+	}
+	return r
+}
+
+// log prints the summary of the run.
+func (r *statsReport) log() {
+	log.Printf("made %d requests (%d retries, %d cache hits), transferring %s bytes; held %s by rate limits and %s by backoff\n",
+		r.Total, r.Retries, r.CacheHits, format(int(r.Bytes)), r.Held.Round(time.Millisecond), r.Backoff.Round(time.Millisecond))
+	endpoints := make([]string, 0, len(r.Requests))
+	for e := range r.Requests {
+		endpoints = append(endpoints, e)
+	}
+	sort.Strings(endpoints)
+	for _, e := range endpoints {
+		log.Printf("  %6d %s\n", r.Requests[e], e)
+	}
+	keys := make([]string, 0, len(r.RateLimits))
+	for key := range r.RateLimits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rr := r.RateLimits[key]
+		log.Printf("%s rate limit: %d remaining, resets at %s\n", key, rr.Remaining, rr.Reset.Local().Format("Mon Jan _2 15:04:05"))
+	}
+	for _, p := range r.Phases {
+		log.Printf("%s phase took %s\n", p.Name, p.Duration.Round(time.Millisecond))
+	}
+}
+
+// write writes the summary of the run as JSON to filename.
+func (r *statsReport) write(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
@@ -0,0 +1,239 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveTokens returns the access tokens specified by the first of
+// the following sources which supplies any: --token, --token-file,
+// --token-command, the --token-env environment variable and ~/.netrc.
+// Files, command output and the environment variable may specify a
+// pool of several tokens, separated by newlines or commas. Returns no
+// tokens if none is specified, in which case requests are made
+// anonymously.
+func resolveTokens(c *Config) ([]string, error) {
+	if len(c.Token) > 0 {
+		return []string{c.Token}, nil
+	}
+	if len(c.TokenFile) > 0 {
+		data, err := ioutil.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		if tokens := splitTokens(string(data)); len(tokens) > 0 {
+			return tokens, nil
+		}
+		return nil, errors.New(fmt.Sprintf("no tokens found in %s", c.TokenFile))
+	}
+	if len(c.TokenCommand) > 0 {
+		var stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", c.TokenCommand)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%q failed: %s: %s", c.TokenCommand, err, strings.TrimSpace(stderr.String())))
+		}
+		if tokens := splitTokens(string(out)); len(tokens) > 0 {
+			return tokens, nil
+		}
+		return nil, errors.New(fmt.Sprintf("no tokens output by %q", c.TokenCommand))
+	}
+	if len(c.TokenEnv) > 0 {
+		if tokens := splitTokens(os.Getenv(c.TokenEnv)); len(tokens) > 0 {
+			return tokens, nil
+		}
+	}
+	return netrcToken(c.Host)
+}
+
+// resolveHostToken returns the access token for the GitHub instance
+// whose API root is host, taken from the environment variable named
+// for its hostname by --host-token-env or else from ~/.netrc. Returns
+// an empty token if none is specified.
+func resolveHostToken(c *Config, hostname, host string) (string, error) {
+	if env, ok := c.HostTokenEnv[hostname]; ok {
+		if tokens := splitTokens(os.Getenv(env)); len(tokens) > 0 {
+			return tokens[0], nil
+		}
+	}
+	tokens, err := netrcToken(host)
+	if err != nil || len(tokens) == 0 {
+		return "", err
+	}
+	return tokens[0], nil
+}
+
+// splitTokens splits s into the tokens it contains, separated by
+// newlines or commas.
+func splitTokens(s string) []string {
+	var tokens []string
+	for _, t := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ','
+	}) {
+		if t = strings.TrimSpace(t); len(t) > 0 {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// netrcToken returns the password of the entry for the hostname of the
+// API root in the user's .netrc file ($NETRC, or ~/.netrc), if any.
+func netrcToken(host string) ([]string, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	path := os.Getenv("NETRC")
+	if len(path) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Entries are whitespace-separated key/value pairs, each beginning
+	// with "machine <name>" or "default". Macro definitions are not
+	// supported.
+	type entry struct {
+		machine, password string
+	}
+	var entries []*entry
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "default":
+			entries = append(entries, &entry{})
+		case "machine", "password", "login", "account":
+			if i+1 == len(fields) {
+				continue
+			}
+			key, value := fields[i], fields[i+1]
+			i++
+			if key == "machine" {
+				entries = append(entries, &entry{machine: value})
+			} else if key == "password" && len(entries) > 0 {
+				entries[len(entries)-1].password = value
+			}
+		}
+	}
+	// The default entry, if any, must follow all machine entries.
+	for _, e := range entries {
+		if (e.machine == u.Hostname() || len(e.machine) == 0) && len(e.password) > 0 {
+			return []string{e.password}, nil
+		}
+	}
+	return nil, nil
+}
+
+// A tokenRotator is a tokenSource able to substitute another token for
+// one which has exceeded its rate limit.
+type tokenRotator interface {
+	tokenSource
+	// rotate records that token exceeded its rate limit, returning
+	// whether another token is available for immediate use.
+	rotate(token string, rle *rateLimitError) bool
+	// spare returns whether a token other than token isn't exhausted.
+	spare(token string) bool
+}
+
+// tokenPool is a tokenRotator which supplies one of several tokens,
+// moving on to the next when the current token exceeds its rate limit.
+// Each token has its own rate limit, so a run needn't sleep until a
+// reset unless every token in the pool is exhausted.
+type tokenPool struct {
+	mu      sync.Mutex
+	tokens  []string
+	resets  []time.Time // Time at which each token's rate limit resets
+	current int
+}
+
+func newTokenPool(tokens []string) *tokenPool {
+	return &tokenPool{tokens: tokens, resets: make([]time.Time, len(tokens))}
+}
+
+// token implements the tokenSource interface.
+func (tp *tokenPool) token(ctx context.Context, repo string) (string, error) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.current = tp.available()
+	return tp.tokens[tp.current], nil
+}
+
+// available returns the index of the first token, starting with the
+// current token, which isn't exhausted. If all are exhausted, returns
+// the index of the token which resets soonest.
+func (tp *tokenPool) available() int {
+	now := time.Now()
+	soonest := tp.current
+	for i := 0; i < len(tp.tokens); i++ {
+		idx := (tp.current + i) % len(tp.tokens)
+		if !tp.resets[idx].After(now) {
+			return idx
+		}
+		if tp.resets[idx].Before(tp.resets[soonest]) {
+			soonest = idx
+		}
+	}
+	return soonest
+}
+
+// rotate implements the tokenRotator interface.
+func (tp *tokenPool) rotate(token string, rle *rateLimitError) bool {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	for i, t := range tp.tokens {
+		if t == token {
+			tp.resets[i] = time.Now().Add(rle.expiration())
+		}
+	}
+	idx := tp.available()
+	return !tp.resets[idx].After(time.Now())
+}
+
+// spare implements the tokenRotator interface.
+func (tp *tokenPool) spare(token string) bool {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	now := time.Now()
+	for i, t := range tp.tokens {
+		if t != token && !tp.resets[i].After(now) {
+			return true
+		}
+	}
+	return false
+}
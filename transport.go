@@ -0,0 +1,104 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// newTransport returns the HTTP transport configured for the API host:
+// an additional CA bundle for private certificate authorities, a client
+// certificate for mutual TLS, an explicit proxy (otherwise taken from
+// the HTTPS_PROXY environment variable and friends) and, for staging
+// instances only, skipping verification of the server's certificate.
+// The TLS settings apply only to requests to the API host (--host);
+// other hosts, which also receive access tokens, are verified as usual.
+func newTransport(c *Config) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(c.Proxy) > 0 {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid proxy URL %q: %s", c.Proxy, err))
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if len(c.CACert) == 0 && len(c.ClientCert) == 0 && len(c.ClientKey) == 0 && !c.InsecureSkipVerify {
+		return transport, nil
+	}
+	u, err := url.Parse(c.Host)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{}
+
+	if len(c.CACert) > 0 {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New(fmt.Sprintf("no PEM encoded certificates found in %s", c.CACert))
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(c.ClientCert) > 0 || len(c.ClientKey) > 0 {
+		if len(c.ClientCert) == 0 || len(c.ClientKey) == 0 {
+			return nil, errors.New("--client-cert and --client-key must be specified together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if c.InsecureSkipVerify {
+		log.Printf("WARNING: not verifying the TLS certificate of %s\n", u.Host)
+		tlsConfig.InsecureSkipVerify = true
+	}
+	apiTransport := transport.Clone()
+	apiTransport.TLSClientConfig = tlsConfig
+	return &splitTransport{host: u.Host, hostTransport: apiTransport, base: transport}, nil
+}
+
+// splitTransport makes requests to one host through a transport of its
+// own, and all other requests through base.
+type splitTransport struct {
+	host          string
+	hostTransport http.RoundTripper
+	base          http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *splitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == t.host {
+		return t.hostTransport.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,46 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+//
+// Author: Spencer Kimball (spencer.kimball@gmail.com)
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInsecureSkipVerifyOnlyForAPIHost(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	api := httptest.NewTLSServer(handler)
+	defer api.Close()
+	other := httptest.NewTLSServer(handler)
+	defer other.Close()
+
+	transport, err := newTransport(&Config{Host: api.URL + "/", InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatalf("expected API host to skip verification: %s", err)
+	}
+	resp.Body.Close()
+	if resp, err := client.Get(other.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected other hosts to be verified")
+	}
+}